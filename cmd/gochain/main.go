@@ -1,20 +1,70 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/miner"
+	"github.com/karimseh/gochain/pkg/network"
 	"github.com/karimseh/gochain/pkg/types"
 	"github.com/karimseh/gochain/pkg/wallet"
 )
 
-var bc *blockchain.Blockchain
+var (
+	bc     *blockchain.Blockchain
+	cfg    = config.Default()
+	signer string   // Wallet sealing proof-of-authority blocks
+	args   []string // Command and its arguments, after the global flags
+)
 
 func main() {
+	flag.StringVar(&cfg.DataDir, "datadir", cfg.DataDir, "Directory holding the chain database")
+	flag.StringVar(&cfg.WalletDir, "walletdir", cfg.WalletDir, "Directory holding wallet files, <datadir>/wallets with --datadir")
+	flag.StringVar(&cfg.Network, "network", cfg.Network, "Name of the chain, each one is stored apart")
+	flag.BoolVar(&cfg.InMemory, "inmemory", cfg.InMemory, "Keep the chain in memory, nothing is written to disk")
+	flag.StringVar(&signer, "signer", "", "Address of the wallet sealing blocks on a proof-of-authority chain")
+	flag.Usage = printUsage
+	flag.Parse()
+	args = flag.Args()
+
+	// A custom data dir keeps its wallets unless told otherwise
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if explicit["datadir"] && !explicit["walletdir"] {
+		cfg.WalletDir = ""
+	}
+
+	// Commands opening their own chain, or none at all. Wallets come first so
+	// that signers can be listed in a genesis file before init.
+	if len(args) > 0 {
+		switch args[0] {
+		case "createwallet":
+			handleCreateWallet()
+			return
+		case "init":
+			handleInit()
+			return
+		case "dev":
+			handleDev()
+			return
+		}
+	}
+
 	var err error
-	bc, err = blockchain.NewBlockchain()
+	bc, err = blockchain.NewBlockchain(chainOptions()...)
 	if err != nil {
 		log.Fatalf("Failed to initialize blockchain: %v", err)
 	}
@@ -22,41 +72,88 @@ func main() {
 		_ = bc.CloseDB()
 	}()
 
-	if len(os.Args) < 2 {
+	if len(args) < 1 {
 		printUsage()
 		return
 	}
 
 	// Handle commands that need continuous operation
-	switch os.Args[1] {
-	case "createwallet":
-		handleCreateWallet()
-
+	switch args[0] {
 	case "balance":
 		handleBalance()
 	case "status":
 		handleStatus()
 	case "printchain":
 		handlePrintChain()
+	case "block":
+		handleBlock()
+	case "tx":
+		handleTx()
+	case "startnode":
+		handleStartNode()
+	case "rewind":
+		handleRewind()
+	case "mine":
+		handleMine()
 	default:
 		printUsage()
 	}
 }
 
+// chainOptions opens the chain as the global flags say.
+func chainOptions() []blockchain.Option {
+	opts := []blockchain.Option{blockchain.WithConfig(cfg)}
+	if signer != "" {
+		w, err := wallet.LoadWalletFrom(cfg.Wallets(), signer)
+		if err != nil {
+			log.Fatalf("Failed to load signer wallet: %v", err)
+		}
+		opts = append(opts, blockchain.WithSigner(w))
+	}
+	return opts
+}
+
+func handleInit() {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	path := fs.String("genesis", "", "Genesis file to create the chain from")
+	_ = fs.Parse(args[1:])
+	if *path == "" {
+		log.Fatal("Usage: init --genesis <file.json>")
+	}
+
+	genesis, err := blockchain.LoadGenesis(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chain, err := blockchain.NewBlockchain(append(chainOptions(), blockchain.WithGenesis(genesis))...)
+	if err != nil {
+		log.Fatalf("Failed to initialize blockchain: %v", err)
+	}
+	defer func() {
+		_ = chain.CloseDB()
+	}()
+
+	block, err := chain.GetGenesisBlock()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Chain %d initialized\nGenesis Hash: %x\nHeight: %d\n", genesis.ChainID, block.Hash, chain.GetHeight())
+}
+
 func handleCreateWallet() {
 	w := wallet.NewWallet()
 
-	if err := w.SaveToFile(); err != nil {
+	if err := w.SaveToDir(cfg.Wallets()); err != nil {
 		log.Fatal(err)
 	}
 	fmt.Printf("New wallet created:\nAddress: %s\n", w.Address)
 }
 
 func handleBalance() {
-	if len(os.Args) < 3 {
+	if len(args) < 2 {
 		log.Fatal("Usage: balance <address>")
 	}
-	address := os.Args[2]
+	address := args[1]
 
 	balance, err := bc.State.GetBalance(address)
 	if err != nil {
@@ -74,6 +171,7 @@ func handleStatus() {
 	fmt.Printf("Chain Height: %d\n", bc.GetHeight())
 	fmt.Printf("Last Block Hash: %x\n", lastBlock.Hash)
 	fmt.Printf("Pending Transactions: %d\n", pending)
+	fmt.Printf("Queued Transactions: %d\n", bc.Mempool.QueuedCount())
 }
 
 func handlePrintChain() {
@@ -92,11 +190,253 @@ func handlePrintChain() {
 	}
 }
 
+func handleBlock() {
+	if len(args) < 2 {
+		log.Fatal("Usage: block <height|hash>")
+	}
+
+	var block *types.Block
+	var err error
+	if height, parseErr := strconv.ParseUint(args[1], 10, 64); parseErr == nil {
+		block, err = bc.GetBlockByHeight(height)
+	} else {
+		hash, decodeErr := hex.DecodeString(args[1])
+		if decodeErr != nil {
+			log.Fatalf("Invalid height or hash: %s", args[1])
+		}
+		block, err = bc.GetBlock(hash)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Block %d\n", block.Header.Index)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	fmt.Printf("Parent Hash: %x\n", block.Header.ParentHash)
+	fmt.Printf("Timestamp: %s\n", time.Unix(block.Header.Timestamp, 0).UTC().Format(time.RFC3339))
+	fmt.Printf("Miner: %s\n", block.Header.Miner)
+	fmt.Printf("Difficulty: %d\n", block.Header.Difficulty)
+	fmt.Printf("State Root: %x\n", block.StateRoot)
+	fmt.Printf("Transactions: %d\n", len(block.Transactions))
+	for _, tx := range block.Transactions {
+		fmt.Printf("  %x  %s -> %s  %d\n", tx.Hash, tx.From, tx.To, tx.Ammount)
+	}
+}
+
+func handleTx() {
+	if len(args) < 2 {
+		log.Fatal("Usage: tx <hash>")
+	}
+	hash, err := hex.DecodeString(args[1])
+	if err != nil {
+		log.Fatalf("Invalid hash: %v", err)
+	}
+
+	tx, _, err := bc.GetTransaction(hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	receipt, err := bc.GetReceipt(hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Transaction %x\n", tx.Hash)
+	fmt.Printf("From: %s\n", tx.From)
+	fmt.Printf("To: %s\n", tx.To)
+	fmt.Printf("Amount: %d\n", tx.Ammount)
+	fmt.Printf("Nonce: %d\n", tx.Nonce)
+	fmt.Printf("Block: %d (%x)\n", receipt.BlockHeight, receipt.BlockHash)
+	fmt.Printf("Index: %d\n", receipt.Index)
+	fmt.Printf("Status: %s\n", receipt.Status)
+	fmt.Printf("Fee Paid: %d\n", receipt.FeePaid)
+	fmt.Printf("Sender Nonce: %d\n", receipt.Nonce)
+}
+
+func handleRewind() {
+	if len(args) < 2 {
+		log.Fatal("Usage: rewind <height>")
+	}
+	height, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid height: %v", err)
+	}
+
+	if err := bc.Rewind(height); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Chain rewound to height %d\n", bc.GetHeight())
+}
+
+func handleMine() {
+	fs := flag.NewFlagSet("mine", flag.ExitOnError)
+	address := fs.String("miner", "", "Address receiving block rewards")
+	threads := fs.Int("threads", runtime.NumCPU(), "Number of mining goroutines")
+	listen := fs.String("listen", "", "Address to accept peer connections on, mined blocks stay local without it or --peers")
+	peers := fs.String("peers", "", "Comma separated list of seed peers")
+	_ = fs.Parse(args[1:])
+	if *address == "" {
+		log.Fatal("Usage: mine --miner <address> [--threads N] [--listen addr] [--peers a,b]")
+	}
+
+	if *listen != "" || *peers != "" {
+		server := startServer(*listen, *peers)
+		defer server.Stop()
+		fmt.Printf("Node %s listening on %s (%d peers)\n", server.NodeID(), server.Addr(), server.PeerCount())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if pow, ok := bc.Engine().(*consensus.PoWEngine); ok {
+		pow.Threads = *threads
+	}
+	worker := miner.NewWorker(bc, *address)
+	worker.OnBlock = func(block *types.Block) {
+		fmt.Printf("Mined block %d: %x (%d txs, %.0f H/s)\n",
+			block.Header.Index, block.Hash, len(block.Transactions)-1, worker.HashRate())
+	}
+	worker.OnReject = func(err error) {
+		fmt.Printf("Warning: %v\n", err)
+	}
+
+	fmt.Printf("Mining to %s with %d threads\n", *address, *threads)
+	if err := worker.Run(ctx); err != nil {
+		fmt.Printf("Mining stopped: %v\n", err)
+		return
+	}
+	fmt.Println("Shutting down miner...")
+}
+
+func handleDev() {
+	fs := flag.NewFlagSet("dev", flag.ExitOnError)
+	accounts := fs.Int("accounts", 3, "Number of pre-funded dev accounts")
+	balance := fs.Uint64("balance", 1_000_000, "Balance of each dev account")
+	period := fs.Duration("period", 0, "Seal a block every period instead of on each transaction")
+	listen := fs.String("listen", ":3000", "Address to accept peer connections on")
+	rewards := fs.String("miner", "", "Address receiving block rewards, the first dev account by default")
+	_ = fs.Parse(args[1:])
+	if *accounts <= 0 && *rewards == "" {
+		log.Fatal("Usage: dev needs --miner <address> when --accounts is 0")
+	}
+
+	// The chain is gone on exit, and so are the funds of its wallets
+	walletDir, err := os.MkdirTemp("", "gochain-dev-wallets-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(walletDir)
+
+	alloc := make(map[string]uint64)
+	wallets := make([]*wallet.Wallet, 0, *accounts)
+	for range *accounts {
+		w := wallet.NewWallet()
+		if err := w.SaveToDir(walletDir); err != nil {
+			log.Fatal(err)
+		}
+		alloc[w.Address] = *balance
+		wallets = append(wallets, w)
+	}
+	if *rewards == "" {
+		*rewards = wallets[0].Address
+	}
+
+	genesis := &blockchain.Genesis{
+		ChainID:     1337,
+		Timestamp:   time.Now().Unix(),
+		BlockReward: types.CoinbaseAmount,
+		Alloc:       alloc,
+		Engine:      blockchain.EngineInstant,
+	}
+	dev, err := blockchain.NewBlockchain(
+		blockchain.WithConfig(config.Config{InMemory: true}),
+		blockchain.WithGenesis(genesis),
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize dev chain: %v", err)
+	}
+	defer func() {
+		_ = dev.CloseDB()
+	}()
+
+	server := network.NewServer(network.Config{ListenAddr: *listen}, dev, dev.Mempool)
+	if err := server.Start(); err != nil {
+		log.Fatalf("Failed to start node: %v", err)
+	}
+	defer server.Stop()
+
+	fmt.Printf("Dev chain listening on %s\n", server.Addr())
+	fmt.Printf("Dev wallets in %s, removed on exit\n", walletDir)
+	for _, w := range wallets {
+		fmt.Printf("Account %s: %d\n", w.Address, *balance)
+	}
+	fmt.Printf("Block rewards to %s\n", *rewards)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	sealer := miner.NewDevSealer(dev, *rewards)
+	sealer.Interval = *period
+	sealer.OnBlock = func(block *types.Block) {
+		fmt.Printf("Sealed block %d: %x (%d txs)\n", block.Header.Index, block.Hash, len(block.Transactions)-1)
+	}
+	if err := sealer.Run(ctx); err != nil {
+		fmt.Printf("Dev chain stopped: %v\n", err)
+		return
+	}
+	fmt.Println("Shutting down dev chain...")
+}
+
+func handleStartNode() {
+	fs := flag.NewFlagSet("startnode", flag.ExitOnError)
+	listen := fs.String("listen", ":3000", "Address to accept peer connections on")
+	peers := fs.String("peers", "", "Comma separated list of seed peers")
+	_ = fs.Parse(args[1:])
+
+	server := startServer(*listen, *peers)
+	defer server.Stop()
+
+	fmt.Printf("Node %s listening on %s (%d peers)\n", server.NodeID(), server.Addr(), server.PeerCount())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	fmt.Println("Shutting down node...")
+}
+
+// startServer runs a P2P node for bc, which announces the blocks and
+// transactions the chain takes in.
+func startServer(listen, peers string) *network.Server {
+	var seeds []string
+	for _, addr := range strings.Split(peers, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			seeds = append(seeds, addr)
+		}
+	}
+
+	server := network.NewServer(network.Config{
+		ListenAddr: listen,
+		Seeds:      seeds,
+	}, bc, bc.Mempool)
+	if err := server.Start(); err != nil {
+		log.Fatalf("Failed to start node: %v", err)
+	}
+	return server
+}
+
 func printUsage() {
 	fmt.Println("GoChain CLI - Account-Based Blockchain")
-	fmt.Println("Usage:")
+	fmt.Println("Usage: gochain [--datadir dir] [--walletdir dir] [--network name] [--inmemory] [--signer address] <command>")
+	fmt.Println("Commands:")
+	fmt.Println("  init --genesis <file> - Create the chain from a genesis file")
 	fmt.Println("  createwallet          - Generate new wallet")
 	fmt.Println("  balance <address>     - Check account balance")
 	fmt.Println("  status                - Show blockchain status")
 	fmt.Println("  printchain            - Display all blocks")
+	fmt.Println("  block <height|hash>   - Show a block of the canonical chain or any stored one")
+	fmt.Println("  tx <hash>             - Show a transaction and its receipt")
+	fmt.Println("  rewind <height>       - Revert chain and state to height")
+	fmt.Println("  mine --miner <address> [--threads N] [--listen addr] [--peers a,b] - Mine blocks until interrupted")
+	fmt.Println("  startnode [--listen addr] [--peers a,b] - Run a P2P node")
+	fmt.Println("  dev [--accounts N] [--balance B] [--period D] [--listen addr] [--miner address] - Run a throwaway instant-seal chain")
 }
@@ -1,84 +1,211 @@
 package blockchain
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"sync"
 
-	"github.com/dgraph-io/badger/v4"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
 	"github.com/karimseh/gochain/pkg/mempool"
 	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/storage"
 	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+)
+
+var (
+	ErrKnownBlock    = errors.New("block already known")
+	ErrUnknownParent = errors.New("unknown parent block")
+	ErrBadBlock      = errors.New("block on an invalid branch")
+	ErrInvalidBlock  = errors.New("block breaks consensus rules")
 )
 
 type Blockchain struct {
-	DB       *badger.DB
-	State    *state.State
-	Mempool  *mempool.TxPool
-	LastHash []byte
-	height   uint64
-	genesis  *types.Block
-	mu       sync.RWMutex
+	DB        storage.KV
+	State     *state.State
+	Mempool   *mempool.TxPool
+	Template  TemplateConfig
+	LastHash  []byte
+	height    uint64
+	genesis   *types.Block
+	engine    consensus.Engine
+	validator *consensus.Validator
+	cfg       config.Config
+	config    *Genesis
+	mu        sync.RWMutex
+	badBlocks map[string]struct{} // Blocks that failed to execute in a reorg
+	signer    *wallet.Wallet
+
+	headSubs map[chan *types.Block]struct{}
+	subsMu   sync.Mutex
+}
+
+// Option customizes a Blockchain created by NewBlockchain.
+type Option func(bc *Blockchain)
+
+// WithEngine runs the chain under engine instead of the one its genesis
+// selects.
+func WithEngine(engine consensus.Engine) Option {
+	return func(bc *Blockchain) {
+		bc.engine = engine
+	}
+}
+
+// WithConfig stores the chain where cfg says instead of the default
+// location.
+func WithConfig(cfg config.Config) Option {
+	return func(bc *Blockchain) {
+		bc.cfg = cfg
+	}
+}
+
+// WithGenesis creates the chain from g instead of DefaultGenesis. Opening an
+// existing database created from another genesis fails with
+// ErrGenesisMismatch.
+func WithGenesis(g *Genesis) Option {
+	return func(bc *Blockchain) {
+		bc.config = g
+	}
+}
+
+// WithSigner seals the blocks of a proof-of-authority chain with w.
+func WithSigner(w *wallet.Wallet) Option {
+	return func(bc *Blockchain) {
+		bc.signer = w
+	}
 }
 
-func NewBlockchain() (*Blockchain, error) {
-	dbPath := dbPath()
-	db, err := openDB(dbPath)
+func NewBlockchain(opts ...Option) (*Blockchain, error) {
+	bc := &Blockchain{
+		cfg:       config.Default(),
+		Template:  DefaultTemplateConfig(),
+		badBlocks: make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(bc)
+	}
+	if bc.config != nil {
+		if err := bc.config.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid genesis: %w", err)
+		}
+	}
+	db, err := openDB(bc.cfg)
 	if err != nil {
 		return nil, err
 	}
-	bc := &Blockchain{DB: db, State: state.NewState(db), Mempool: mempool.NewTxPool()}
+	bc.DB = db
+	bc.State = state.NewState(db)
 
 	if err := bc.initialize(); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
+	if bc.engine == nil {
+		bc.engine = bc.config.newEngine(bc.signer)
+	}
+	bc.validator = consensus.NewValidator(chainReader{bc}, bc.engine)
+	bc.validator.BlockReward = bc.config.BlockReward
+	bc.State.SetChainID(bc.config.ChainID)
+
+	poolConfig := mempool.DefaultConfig()
+	poolConfig.ChainID = bc.config.ChainID
+	bc.Mempool = mempool.NewTxPoolWithConfig(bc.State, poolConfig)
 
 	return bc, nil
 }
 
+func (bc *Blockchain) Engine() consensus.Engine {
+	return bc.engine
+}
+
+// GenesisConfig returns the config the chain was created from.
+func (bc *Blockchain) GenesisConfig() *Genesis {
+	return bc.config
+}
+
 func (bc *Blockchain) initialize() error {
-	return bc.DB.Update(func(txn *badger.Txn) error {
-		_, err := txn.Get([]byte("lastHash"))
-		if err == badger.ErrKeyNotFound {
-			return bc.createGenesisBlock()
+	lastHash, err := bc.DB.Get(storage.LastHashKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		if bc.config == nil {
+			bc.config = DefaultGenesis()
 		}
-		return bc.loadChainState(txn)
-	})
+		return bc.createGenesisBlock()
+	}
+	if err != nil {
+		return err
+	}
+	return bc.loadChainState(lastHash)
 }
 
 func (bc *Blockchain) createGenesisBlock() error {
-	genesis := types.NewBlock(0, []*types.Transaction{}, []byte{}, "GENESIS")
-	genesis.Hash = genesis.CalculateHash()
+	alloc := bc.State.Copy()
+	genesis, err := bc.config.toBlock(alloc)
+	if err != nil {
+		return err
+	}
 
-	return bc.DB.Update(func(txn *badger.Txn) error {
-		if err := txn.Set(genesis.Hash, genesis.Serialize()); err != nil {
-			return err
-		}
-		if err := txn.Set([]byte("lastHash"), genesis.Hash); err != nil {
-			return err
-		}
-		bc.LastHash = genesis.Hash
-		bc.genesis = genesis
-		bc.height = 0
-		return nil
-	})
+	batch := bc.DB.NewBatch()
+	if err := alloc.WriteTo(batch); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.BlockKey(genesis.Hash), genesis.Serialize()); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.TotalWorkKey(genesis.Hash), blockWork(genesis.Header.Difficulty).Bytes()); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.HeightKey(0), genesis.Hash); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.LastHashKey, genesis.Hash); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.State.Merge(alloc)
+	bc.LastHash = genesis.Hash
+	bc.genesis = genesis
+	bc.height = 0
+	return nil
 }
 
-func (bc *Blockchain) loadChainState(txn *badger.Txn) error {
-	item, err := txn.Get([]byte("lastHash"))
+func (bc *Blockchain) loadChainState(lastHash []byte) error {
+	lastBlock, err := bc.GetBlock(lastHash)
 	if err != nil {
 		return err
 	}
-	return item.Value(func(val []byte) error {
-		lastBlock, err := bc.GetBlock(val)
-		if err != nil {
-			return err
-		}
 
-		bc.mu.Lock()
-		defer bc.mu.Unlock()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
 
-		bc.LastHash = lastBlock.Hash
-		bc.height = lastBlock.Header.Index
-		bc.genesis, _ = bc.GetGenesisBlock()
-		return nil
-	})
+	bc.LastHash = lastBlock.Hash
+	bc.height = lastBlock.Header.Index
+	if err := bc.indexHeights(lastBlock); err != nil {
+		return err
+	}
+	if bc.genesis, err = bc.GetGenesisBlock(); err != nil {
+		return err
+	}
+	return bc.loadGenesisConfig()
+}
+
+// loadGenesisConfig reads the config stored in the genesis block, checking
+// it against the one the chain was opened with, if any. Genesis blocks older
+// than genesis configs follow DefaultGenesis.
+func (bc *Blockchain) loadGenesisConfig() error {
+	stored := DefaultGenesis()
+	if extra := bc.genesis.Header.Extra; len(extra) > 0 {
+		var err error
+		if stored, err = decodeGenesis(extra); err != nil {
+			return err
+		}
+	}
+	if bc.config != nil && !bytes.Equal(bc.config.encode(), stored.encode()) {
+		return fmt.Errorf("%w: %x", ErrGenesisMismatch, bc.genesis.Hash)
+	}
+	bc.config = stored
+	return nil
 }
@@ -2,9 +2,12 @@ package blockchain
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math/big"
 
-	"github.com/dgraph-io/badger/v4"
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/storage"
 	"github.com/karimseh/gochain/pkg/types"
 )
 
@@ -17,29 +20,36 @@ func (bc *Blockchain) GetHeight() uint64 {
 func (bc *Blockchain) GetBlock(hash []byte) (*types.Block, error) {
 	bc.mu.RLock()
 	defer bc.mu.RUnlock()
+	return bc.getBlock(hash)
+}
 
-	var block *types.Block
+func (bc *Blockchain) getBlock(hash []byte) (*types.Block, error) {
+	return readBlock(bc.DB, hash)
+}
 
-	err := bc.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(hash)
-		if err != nil {
-			return err
-		}
+func readBlock(r storage.Reader, hash []byte) (*types.Block, error) {
+	data, err := r.Get(storage.BlockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	return types.DeserializeBlock(data)
+}
 
-		return item.Value(func(val []byte) error {
-			block, err = types.DeserializeBlock(val)
-			return err
-		})
-	})
+// chainReader reads blocks without taking bc.mu, for use while it is held.
+type chainReader struct {
+	bc *Blockchain
+}
 
-	return block, err
+func (r chainReader) GetBlock(hash []byte) (*types.Block, error) {
+	return r.bc.getBlock(hash)
 }
 
 func (bc *Blockchain) GetLastBlock() *types.Block {
 	bc.mu.RLock()
-	defer bc.mu.RUnlock()
+	lastHash := bc.LastHash
+	bc.mu.RUnlock()
 
-	block, _ := bc.GetBlock(bc.LastHash)
+	block, _ := bc.GetBlock(lastHash)
 	return block
 }
 
@@ -48,113 +58,132 @@ func (bc *Blockchain) GetGenesisBlock() (*types.Block, error) {
 		return bc.genesis, nil
 	}
 
-	var genesis *types.Block
-	err := bc.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lastHash"))
-		if err != nil {
-			return err
-		}
-
-		return item.Value(func(val []byte) error {
-			// Follow the chain back to genesis
-			currentHash := val
-			for {
-				item, err := txn.Get(currentHash)
-				if err != nil {
-					return err
-				}
-
-				var block *types.Block
-				err = item.Value(func(blockData []byte) error {
-					block, err = types.DeserializeBlock(blockData)
-					return err
-				})
-
-				if block.Header.Index == 0 {
-					genesis = block
-					return nil
-				}
-				currentHash = block.Header.ParentHash
-			}
-		})
-	})
-	return genesis, err
+	return getBlockByHeight(bc.DB, 0)
 }
 
+// AddBlock imports a block on top of any known block. Blocks extending the
+// current tip are executed right away, others are kept as side chain and
+// trigger a reorganization once their branch carries more work. Blocks
+// breaking the consensus rules are rejected with ErrInvalidBlock. Whenever the
+// tip moves, the mempool is revalidated against the new state. A block that
+// failed to execute is dropped with every stored block descending from it,
+// and they and their children are rejected with ErrBadBlock.
 func (bc *Blockchain) AddBlock(block *types.Block) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
-	if block.Header.Index != bc.height+1 {
-		return fmt.Errorf("invalid block index: %d, expected: %d", block.Header.Index, bc.height+1)
+
+	if _, bad := bc.badBlocks[string(block.Hash)]; bad {
+		return fmt.Errorf("%w: %x", ErrBadBlock, block.Hash)
 	}
-	if !bytes.Equal(block.Header.ParentHash, bc.LastHash) {
-		return fmt.Errorf("invalid parent hash")
+	if _, bad := bc.badBlocks[string(block.Header.ParentHash)]; bad {
+		return fmt.Errorf("%w: parent %x", ErrBadBlock, block.Header.ParentHash)
 	}
-
-	if err := block.Validate(); err != nil {
-		return err
+	if _, err := bc.getBlock(block.Hash); err == nil {
+		return ErrKnownBlock
 	}
-
-	if err := bc.State.ApplyBlock(block); err != nil {
+	parent, err := bc.getBlock(block.Header.ParentHash)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("%w: %x", ErrUnknownParent, block.Header.ParentHash)
+	}
+	if err != nil {
 		return err
 	}
-	// Update state root
-	stateRoot, err := bc.State.CalculateStateRoot()
+	if err := bc.validator.ValidateBlock(block, parent); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+
+	parentWork, err := bc.getTotalWork(parent.Hash)
 	if err != nil {
 		return err
 	}
-	block.StateRoot = stateRoot
+	totalWork := new(big.Int).Add(parentWork, blockWork(block.Header.Difficulty))
 
-	err = bc.DB.Update(func(txn *badger.Txn) error {
-		if err := txn.Set(block.Hash, block.Serialize()); err != nil {
-			return err
-		}
-		if err := txn.Set([]byte("lastHash"), block.Hash); err != nil {
+	if bytes.Equal(block.Header.ParentHash, bc.LastHash) {
+		if err := bc.connectBlock(block, totalWork); err != nil {
 			return err
 		}
+		bc.Mempool.RemoveTxs(block.Transactions[1:])
+		bc.Mempool.Revalidate()
 		return nil
-	})
+	}
+
+	if err := bc.storeSideBlock(block, totalWork); err != nil {
+		return err
+	}
+	tipWork, err := bc.getTotalWork(bc.LastHash)
 	if err != nil {
 		return err
 	}
-	bc.LastHash = block.Hash
-	bc.height = block.Header.Index
+	// Ties keep the branch we saw first
+	if totalWork.Cmp(tipWork) <= 0 {
+		return nil
+	}
+	return bc.reorganize(block)
+}
 
-	bc.Mempool.RemoveTxs(block.Transactions[1:])
+// connectBlock executes block on top of the current tip and makes it the new
+// tip. The block, its state changes, its undo journal, its indexes and the
+// new tip are committed in a single transaction, or not at all.
+func (bc *Blockchain) connectBlock(block *types.Block, totalWork *big.Int) error {
+	scratch := bc.State.Copy()
+	if err := bc.executeBlock(scratch, block); err != nil {
+		return err
+	}
+	journal, err := scratch.Journal()
+	if err != nil {
+		return err
+	}
 
+	batch := bc.DB.NewBatch()
+	if err := batch.Put(storage.BlockKey(block.Hash), block.Serialize()); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.UndoKey(block.Hash), journal.Serialize()); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.TotalWorkKey(block.Hash), totalWork.Bytes()); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.HeightKey(block.Header.Index), block.Hash); err != nil {
+		return err
+	}
+	if err := indexTxs(batch, block); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.LastHashKey, block.Hash); err != nil {
+		return err
+	}
+	if err := scratch.WriteTo(batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.State.Merge(scratch)
+	bc.LastHash = block.Hash
+	bc.height = block.Header.Index
+	bc.notifyHead(block)
 	return nil
+}
 
+// executeBlock applies and finalizes block on st and checks the state root
+// it commits to.
+func (bc *Blockchain) executeBlock(st *state.State, block *types.Block) error {
+	if err := st.ApplyBlock(block); err != nil {
+		return err
+	}
+	if err := bc.engine.Finalize(chainReader{bc}, st, block); err != nil {
+		return err
+	}
+	stateRoot, err := st.CalculateStateRoot()
+	if err != nil {
+		return err
+	}
+	return bc.validator.ValidateState(block, stateRoot)
 }
 
+// IterateBlocks calls handler for every canonical block, from the tip down to
+// genesis.
 func (bc *Blockchain) IterateBlocks(handler func(*types.Block) error) error {
-	return bc.DB.View(func(txn *badger.Txn) error {
-		currentHash := bc.LastHash
-
-		for {
-			item, err := txn.Get(currentHash)
-			if err != nil {
-				return err
-			}
-
-			var block *types.Block
-			err = item.Value(func(val []byte) error {
-				block, err = types.DeserializeBlock(val)
-				return err
-			})
-
-			if err != nil {
-				return err
-			}
-
-			if err := handler(block); err != nil {
-				return err
-			}
-
-			if block.Header.Index == 0 {
-				break // Reached genesis block
-			}
-			currentHash = block.Header.ParentHash
-		}
-		return nil
-	})
+	return bc.IterateRange(bc.GetHeight(), 0, handler)
 }
@@ -1,29 +1,15 @@
 package blockchain
 
 import (
-	"os"
-	"path/filepath"
-
-	"github.com/dgraph-io/badger/v4"
-)
-
-const (
-	dbFolder = ".blocks"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/storage"
 )
 
-func openDB(path string) (*badger.DB, error) {
-	opts := badger.DefaultOptions(path)
-	opts.Logger = nil
-
-	if err := os.MkdirAll(path, os.ModePerm); err != nil {
-		return nil, err
+func openDB(cfg config.Config) (storage.KV, error) {
+	if cfg.InMemory {
+		return storage.NewMemory(), nil
 	}
-
-	return badger.Open(opts)
-}
-
-func dbPath() string {
-	return filepath.Join(dbFolder, "chaindata")
+	return storage.OpenBadger(cfg.ChainDir())
 }
 
 func (bc *Blockchain) CloseDB() error {
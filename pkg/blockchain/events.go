@@ -0,0 +1,34 @@
+package blockchain
+
+import "github.com/karimseh/gochain/pkg/types"
+
+// SubscribeHeads returns a channel receiving each new chain tip and a
+// function ending the subscription. A slow subscriber misses heads instead
+// of holding up the chain.
+func (bc *Blockchain) SubscribeHeads() (<-chan *types.Block, func()) {
+	ch := make(chan *types.Block, 1)
+
+	bc.subsMu.Lock()
+	if bc.headSubs == nil {
+		bc.headSubs = make(map[chan *types.Block]struct{})
+	}
+	bc.headSubs[ch] = struct{}{}
+	bc.subsMu.Unlock()
+
+	return ch, func() {
+		bc.subsMu.Lock()
+		delete(bc.headSubs, ch)
+		bc.subsMu.Unlock()
+	}
+}
+
+func (bc *Blockchain) notifyHead(head *types.Block) {
+	bc.subsMu.Lock()
+	defer bc.subsMu.Unlock()
+	for ch := range bc.headSubs {
+		select {
+		case ch <- head:
+		default:
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+)
+
+var ErrGenesisMismatch = errors.New("database holds a different genesis block")
+
+// Consensus engines a genesis can select.
+const (
+	EnginePoW     = "pow"
+	EnginePoA     = "poa"
+	EngineInstant = "instant"
+)
+
+// Genesis describes the first block of a chain and the rules fixed when it
+// is created. The whole config is stored in the genesis block header, so two
+// chains only share a genesis hash when they share the same config.
+type Genesis struct {
+	ChainID     uint64            `json:"chainId"`
+	Timestamp   int64             `json:"timestamp"`
+	Difficulty  int               `json:"difficulty"`
+	BlockReward uint64            `json:"blockReward"`
+	Alloc       map[string]uint64 `json:"alloc,omitempty"` // Balances by address
+
+	Engine  string   `json:"engine,omitempty"`  // EnginePoW if empty
+	Signers []string `json:"signers,omitempty"` // Initial signers of an EnginePoA chain
+	Period  uint64   `json:"period,omitempty"`  // Seconds between EnginePoA blocks
+}
+
+// DefaultGenesis is the genesis of chains created without a genesis file.
+func DefaultGenesis() *Genesis {
+	return &Genesis{
+		ChainID:     1,
+		Timestamp:   1_735_689_600,
+		Difficulty:  consensus.TargetBits,
+		BlockReward: types.CoinbaseAmount,
+	}
+}
+
+// LoadGenesis reads a genesis file in JSON format.
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var g Genesis
+	if err := decoder.Decode(&g); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %s: %w", path, err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid genesis file %s: %w", path, err)
+	}
+	return &g, nil
+}
+
+func (g *Genesis) Validate() error {
+	if g.ChainID == 0 {
+		return fmt.Errorf("chain ID must not be zero")
+	}
+	if g.Difficulty < 0 || g.Difficulty > consensus.MaxDifficulty {
+		return fmt.Errorf("difficulty %d out of range", g.Difficulty)
+	}
+	switch g.Engine {
+	case "", EnginePoW, EngineInstant:
+		if len(g.Signers) > 0 || g.Period > 0 {
+			return fmt.Errorf("signers and period only apply to the %s engine", EnginePoA)
+		}
+	case EnginePoA:
+		if len(g.Signers) == 0 {
+			return fmt.Errorf("%s engine needs at least one signer", EnginePoA)
+		}
+		for _, signer := range g.Signers {
+			if signer == "" {
+				return fmt.Errorf("signers has an empty address")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown engine %q", g.Engine)
+	}
+
+	var total uint64
+	for address, balance := range g.Alloc {
+		if address == "" {
+			return fmt.Errorf("alloc has an empty address")
+		}
+		var carry uint64
+		if total, carry = bits.Add64(total, balance, 0); carry != 0 {
+			return fmt.Errorf("alloc total overflows")
+		}
+	}
+	return nil
+}
+
+// newEngine returns the engine the chain runs under. signer seals the blocks
+// of a proof-of-authority chain, it may be nil to only verify them.
+func (g *Genesis) newEngine(signer *wallet.Wallet) consensus.Engine {
+	switch g.Engine {
+	case EnginePoA:
+		return consensus.NewPoAEngine(consensus.PoAConfig{
+			Signers: g.Signers,
+			Period:  time.Duration(g.Period) * time.Second,
+			Wallet:  signer,
+		})
+	case EngineInstant:
+		return consensus.NewInstantEngine()
+	default:
+		return consensus.NewPoWEngine(0)
+	}
+}
+
+func (g *Genesis) encode() []byte {
+	// Map keys are sorted by encoding/json, so the encoding is deterministic
+	data, _ := json.Marshal(g)
+	return data
+}
+
+func decodeGenesis(data []byte) (*Genesis, error) {
+	var g Genesis
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("invalid genesis config: %w", err)
+	}
+	return &g, nil
+}
+
+// toBlock funds the alloc on st, a scratch state, and returns the genesis
+// block committing to it.
+func (g *Genesis) toBlock(st *state.State) (*types.Block, error) {
+	addresses := make([]string, 0, len(g.Alloc))
+	for address := range g.Alloc {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		if err := st.SaveAccount(&types.Account{Address: address, Balance: g.Alloc[address]}); err != nil {
+			return nil, err
+		}
+	}
+
+	root, err := st.CalculateStateRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	block := types.NewBlock(0, []*types.Transaction{}, []byte{}, "GENESIS")
+	block.Header.Timestamp = g.Timestamp
+	block.Header.Difficulty = g.Difficulty
+	block.Header.Extra = g.encode()
+	block.StateRoot = root
+	block.Hash = block.CalculateHash()
+	return block, nil
+}
@@ -0,0 +1,188 @@
+package blockchain_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeGenesis(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadGenesis(t *testing.T) {
+	t.Run("Valid File", func(t *testing.T) {
+		path := writeGenesis(t, `{
+			"chainId": 42,
+			"timestamp": 1700000000,
+			"difficulty": 4,
+			"blockReward": 25,
+			"alloc": {"alice": 1000, "bob": 500}
+		}`)
+
+		g, err := blockchain.LoadGenesis(path)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(42), g.ChainID)
+		assert.Equal(t, int64(1700000000), g.Timestamp)
+		assert.Equal(t, 4, g.Difficulty)
+		assert.Equal(t, uint64(25), g.BlockReward)
+		assert.Equal(t, map[string]uint64{"alice": 1000, "bob": 500}, g.Alloc)
+	})
+
+	t.Run("Unknown Field", func(t *testing.T) {
+		_, err := blockchain.LoadGenesis(writeGenesis(t, `{"chainId": 42, "reward": 25}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Missing Chain ID", func(t *testing.T) {
+		_, err := blockchain.LoadGenesis(writeGenesis(t, `{"blockReward": 25}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("PoA Engine", func(t *testing.T) {
+		g, err := blockchain.LoadGenesis(writeGenesis(t, `{
+			"chainId": 42,
+			"engine": "poa",
+			"signers": ["alice", "bob"],
+			"period": 5
+		}`))
+		require.NoError(t, err)
+		assert.Equal(t, blockchain.EnginePoA, g.Engine)
+		assert.Equal(t, []string{"alice", "bob"}, g.Signers)
+		assert.Equal(t, uint64(5), g.Period)
+	})
+
+	t.Run("PoA Without Signers", func(t *testing.T) {
+		_, err := blockchain.LoadGenesis(writeGenesis(t, `{"chainId": 42, "engine": "poa"}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Signers Without PoA", func(t *testing.T) {
+		_, err := blockchain.LoadGenesis(writeGenesis(t, `{"chainId": 42, "signers": ["alice"]}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown Engine", func(t *testing.T) {
+		_, err := blockchain.LoadGenesis(writeGenesis(t, `{"chainId": 42, "engine": "pos"}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Alloc Overflow", func(t *testing.T) {
+		_, err := blockchain.LoadGenesis(writeGenesis(t, `{
+			"chainId": 42,
+			"alloc": {"alice": 18446744073709551615, "bob": 1}
+		}`))
+		assert.ErrorContains(t, err, "overflows")
+	})
+}
+
+func TestNewBlockchain_WithGenesis(t *testing.T) {
+	funded := wallet.NewWallet().Address
+	genesis := &blockchain.Genesis{
+		ChainID:     42,
+		Timestamp:   1_700_000_000,
+		BlockReward: 25,
+		Alloc:       map[string]uint64{funded: 5000},
+	}
+
+	dir := t.TempDir()
+	bc, err := blockchain.NewBlockchain(
+		blockchain.WithConfig(config.Config{DataDir: dir}),
+		blockchain.WithGenesis(genesis),
+		blockchain.WithEngine(consensus.NewInstantEngine()),
+	)
+	require.NoError(t, err)
+
+	block := bc.GetLastBlock()
+	assert.Equal(t, genesis.Timestamp, block.Header.Timestamp)
+
+	balance, err := bc.State.GetBalance(funded)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5000), balance)
+	root, err := bc.State.CalculateStateRoot()
+	require.NoError(t, err)
+	assert.Equal(t, root, block.StateRoot, "Genesis commits to its alloc")
+
+	t.Run("Block Reward", func(t *testing.T) {
+		miner := wallet.NewWallet().Address
+		require.NoError(t, bc.MineBlock(miner))
+		balance, err := bc.State.GetBalance(miner)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(25), balance)
+	})
+	require.NoError(t, bc.CloseDB())
+
+	t.Run("Same Contents Same Hash", func(t *testing.T) {
+		other, err := blockchain.NewBlockchain(blockchain.WithConfig(config.Config{DataDir: t.TempDir()}), blockchain.WithGenesis(genesis))
+		require.NoError(t, err)
+		defer other.CloseDB()
+
+		otherGenesis, err := other.GetGenesisBlock()
+		require.NoError(t, err)
+		assert.Equal(t, block.Hash, otherGenesis.Hash)
+	})
+
+	t.Run("Config Persisted", func(t *testing.T) {
+		reopened, err := blockchain.NewBlockchain(blockchain.WithConfig(config.Config{DataDir: dir}), blockchain.WithEngine(consensus.NewInstantEngine()))
+		require.NoError(t, err)
+		defer reopened.CloseDB()
+
+		assert.Equal(t, genesis, reopened.GenesisConfig())
+		assert.Equal(t, uint64(1), reopened.GetHeight())
+	})
+
+	t.Run("Different Genesis Rejected", func(t *testing.T) {
+		changed := *genesis
+		changed.BlockReward = 50
+		_, err := blockchain.NewBlockchain(blockchain.WithConfig(config.Config{DataDir: dir}), blockchain.WithGenesis(&changed))
+		assert.ErrorIs(t, err, blockchain.ErrGenesisMismatch)
+	})
+}
+
+func TestNewBlockchain_PoAGenesis(t *testing.T) {
+	signer := wallet.NewWallet()
+	genesis := &blockchain.Genesis{
+		ChainID:     42,
+		Timestamp:   1_700_000_000,
+		BlockReward: 25,
+		Engine:      blockchain.EnginePoA,
+		Signers:     []string{signer.Address},
+	}
+
+	bc, err := blockchain.NewBlockchain(
+		blockchain.WithConfig(config.Config{InMemory: true}),
+		blockchain.WithGenesis(genesis),
+		blockchain.WithSigner(signer),
+	)
+	require.NoError(t, err)
+	defer bc.CloseDB()
+
+	t.Run("Engine From Genesis", func(t *testing.T) {
+		assert.IsType(t, &consensus.PoAEngine{}, bc.Engine())
+		require.NoError(t, bc.MineBlock(signer.Address))
+		assert.Equal(t, uint64(1), bc.GetHeight())
+	})
+
+	t.Run("Signers Part Of Genesis Hash", func(t *testing.T) {
+		changed := *genesis
+		changed.Signers = []string{wallet.NewWallet().Address}
+		other, err := blockchain.NewBlockchain(blockchain.WithConfig(config.Config{InMemory: true}), blockchain.WithGenesis(&changed))
+		require.NoError(t, err)
+		defer other.CloseDB()
+
+		ours, err := bc.GetGenesisBlock()
+		require.NoError(t, err)
+		theirs, err := other.GetGenesisBlock()
+		require.NoError(t, err)
+		assert.NotEqual(t, ours.Hash, theirs.Hash)
+	})
+}
@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/karimseh/gochain/pkg/storage"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+var ErrUnknownHeight = errors.New("no block at height")
+
+// GetBlockByHeight returns the block at height on the canonical chain.
+func (bc *Blockchain) GetBlockByHeight(height uint64) (*types.Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return getBlockByHeight(bc.DB, height)
+}
+
+func getBlockByHeight(r storage.Reader, height uint64) (*types.Block, error) {
+	hash, err := r.Get(storage.HeightKey(height))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownHeight, height)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readBlock(r, hash)
+}
+
+// IterateRange calls handler for the canonical blocks from height from to
+// height to, both included, going backward when from is above to. It works
+// on the chain as it was when called and stops at the first error returned by
+// handler.
+func (bc *Blockchain) IterateRange(from, to uint64, handler func(*types.Block) error) error {
+	bc.mu.RLock()
+	height := bc.height
+	snapshot := bc.DB.Snapshot()
+	bc.mu.RUnlock()
+	defer snapshot.Release()
+
+	if top := max(from, to); top > height {
+		return fmt.Errorf("%w: %d, chain height is %d", ErrUnknownHeight, top, height)
+	}
+	for current := from; ; {
+		block, err := getBlockByHeight(snapshot, current)
+		if err != nil {
+			return err
+		}
+		if err := handler(block); err != nil {
+			return err
+		}
+
+		switch {
+		case current == to:
+			return nil
+		case current < to:
+			current++
+		default:
+			current--
+		}
+	}
+}
+
+// indexHeights makes the height index lead to tip, filling in the heights and
+// transactions of chains stored before they were indexed.
+func (bc *Blockchain) indexHeights(tip *types.Block) error {
+	batch := bc.DB.NewBatch()
+	block := tip
+	for {
+		indexed, err := bc.DB.Get(storage.HeightKey(block.Header.Index))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+		if bytes.Equal(indexed, block.Hash) {
+			break
+		}
+		if err := batch.Put(storage.HeightKey(block.Header.Index), block.Hash); err != nil {
+			return err
+		}
+		if err := indexTxs(batch, block); err != nil {
+			return err
+		}
+		if block.Header.Index == 0 {
+			break
+		}
+		if block, err = bc.getBlock(block.Header.ParentHash); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
@@ -0,0 +1,84 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectHeights(t *testing.T, bc *blockchain.Blockchain, from, to uint64) []uint64 {
+	var heights []uint64
+	require.NoError(t, bc.IterateRange(from, to, func(block *types.Block) error {
+		heights = append(heights, block.Header.Index)
+		return nil
+	}))
+	return heights
+}
+
+func TestHeightIndex(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	miner := wallet.NewWallet().Address
+	blocks := []*types.Block{bc.GetLastBlock()}
+	for range 3 {
+		block := mineOn(t, bc, bc.State.Copy(), blocks[len(blocks)-1], miner)
+		require.NoError(t, bc.AddBlock(block))
+		blocks = append(blocks, block)
+	}
+
+	t.Run("Lookup By Height", func(t *testing.T) {
+		for height, expected := range blocks {
+			block, err := bc.GetBlockByHeight(uint64(height))
+			require.NoError(t, err)
+			assert.Equal(t, expected.Hash, block.Hash)
+		}
+
+		_, err := bc.GetBlockByHeight(4)
+		assert.ErrorIs(t, err, blockchain.ErrUnknownHeight)
+	})
+
+	t.Run("Range Iteration", func(t *testing.T) {
+		assert.Equal(t, []uint64{1, 2, 3}, collectHeights(t, bc, 1, 3))
+		assert.Equal(t, []uint64{3, 2, 1, 0}, collectHeights(t, bc, 3, 0))
+		assert.Equal(t, []uint64{2}, collectHeights(t, bc, 2, 2))
+
+		err := bc.IterateRange(0, 4, func(*types.Block) error { return nil })
+		assert.ErrorIs(t, err, blockchain.ErrUnknownHeight)
+	})
+
+	t.Run("Reorg Replaces Heights", func(t *testing.T) {
+		branch := bc.State.Copy()
+		revert(t, bc, branch, blocks[3])
+		revert(t, bc, branch, blocks[2])
+		canonical := append([]*types.Block{}, blocks[:2]...)
+		for _, address := range []string{wallet.NewWallet().Address, miner, miner} {
+			block := mineOn(t, bc, branch, canonical[len(canonical)-1], address)
+			require.NoError(t, bc.AddBlock(block))
+			canonical = append(canonical, block)
+		}
+		require.Equal(t, canonical[4].Hash, bc.LastHash)
+
+		for height, expected := range canonical {
+			block, err := bc.GetBlockByHeight(uint64(height))
+			require.NoError(t, err)
+			assert.Equal(t, expected.Hash, block.Hash, "height %d", height)
+		}
+	})
+
+	t.Run("Rewind Drops Heights", func(t *testing.T) {
+		require.NoError(t, bc.Rewind(1))
+
+		_, err := bc.GetBlockByHeight(2)
+		assert.ErrorIs(t, err, blockchain.ErrUnknownHeight)
+		assert.Equal(t, []uint64{1, 0}, collectHeights(t, bc, 1, 0))
+
+		genesis, err := bc.GetGenesisBlock()
+		require.NoError(t, err)
+		assert.Equal(t, blocks[0].Hash, genesis.Hash)
+	})
+}
@@ -1,32 +1,25 @@
 package blockchain
 
 import (
-	"github.com/karimseh/gochain/pkg/consensus"
-
-	"github.com/karimseh/gochain/pkg/types"
+	"context"
 )
 
 func (bc *Blockchain) MineBlock(miner string) error {
-	// Get transactions from mempool (excluding coinbase)
-	txs := bc.Mempool.GetTxs(50)
-
-	// Create coinbase transaction
-	coinbase := types.NewCoinbaseTx(miner)
-	txs = append([]*types.Transaction{coinbase}, txs...)
+	return bc.MineBlockContext(context.Background(), miner)
+}
 
-	lastBlock := bc.GetLastBlock()
-	newBlock := types.NewBlock(
-		lastBlock.Header.Index+1,
-		txs,
-		lastBlock.Hash,
-		miner,
-	)
+// MineBlockContext seals a new block with the chain's engine and imports it.
+// Sealing stops with ctx's error when ctx is done, for example because a new
+// tip arrived.
+func (bc *Blockchain) MineBlockContext(ctx context.Context, miner string) error {
+	newBlock, err := bc.BuildTemplate(miner)
+	if err != nil {
+		return err
+	}
 
-	// Run Proof-of-Work
-	pow := consensus.NewProofOfWork(newBlock)
-	nonce, hash := pow.Run()
-	newBlock.Header.Nonce = nonce
-	newBlock.Hash = hash
+	if err := bc.engine.Seal(ctx, newBlock); err != nil {
+		return err
+	}
 
 	return bc.AddBlock(newBlock)
 }
@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
 	"github.com/karimseh/gochain/pkg/crypto"
 	"github.com/karimseh/gochain/pkg/types"
 	"github.com/karimseh/gochain/pkg/wallet"
@@ -11,9 +13,14 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// setupBlockchain opens a fresh in-memory chain with instant sealing, so
+// tests neither share data nor spend their time on proof-of-work.
 func setupBlockchain(t *testing.T) (*blockchain.Blockchain, func()) {
 
-	bc, err := blockchain.NewBlockchain()
+	bc, err := blockchain.NewBlockchain(
+		blockchain.WithConfig(config.Config{InMemory: true}),
+		blockchain.WithEngine(consensus.NewInstantEngine()),
+	)
 	require.NoError(t, err)
 
 	return bc, func() {
@@ -42,7 +49,7 @@ func TestMineBlock(t *testing.T) {
 		assert.Len(t, lastBlock.Transactions, 3) // Coinbase + 2 transactions
 		assert.Equal(t, minerWallet.Address, lastBlock.Header.Miner)
 
-		// Verify Proof-of-Work
+		// Verify the seal meets the header difficulty
 		assert.True(t, crypto.ValidateHash(lastBlock.Hash, lastBlock.Header.Difficulty))
 	})
 
@@ -72,6 +79,20 @@ func TestMineBlock(t *testing.T) {
 		assert.Equal(t, uint64(types.CoinbaseAmount), coinbase.Ammount)
 	})
 
+	t.Run("State Root Committed", func(t *testing.T) {
+		bc, cleanup := setupBlockchain(t)
+		defer cleanup()
+
+		require.NoError(t, bc.MineBlock("miner"))
+
+		lastBlock := bc.GetLastBlock()
+		assert.NoError(t, lastBlock.Validate(), "Stored block must still match its hash")
+
+		stateRoot, err := bc.State.CalculateStateRoot()
+		require.NoError(t, err)
+		assert.Equal(t, stateRoot, lastBlock.StateRoot)
+	})
+
 	t.Run("Transaction Pool Cleared", func(t *testing.T) {
 		bc, cleanup := setupBlockchain(t)
 		defer cleanup()
@@ -98,6 +119,7 @@ func createValidTransaction(t *testing.T, bc *blockchain.Blockchain, amount uint
 	}))
 
 	tx := types.NewTransaction(
+		bc.GenesisConfig().ChainID,
 		senderWallet.Address,
 		receiverWallet.Address,
 		amount,
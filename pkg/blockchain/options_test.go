@@ -0,0 +1,85 @@
+package blockchain_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bonusEngine seals without any work and pays the miner a bonus on top of
+// the coinbase.
+type bonusEngine struct{}
+
+const engineBonus = 7
+
+func (bonusEngine) Prepare(chain consensus.ChainReader, header *types.BlockHeader, parent *types.Block) error {
+	header.Difficulty = 0
+	return nil
+}
+
+func (bonusEngine) Seal(ctx context.Context, block *types.Block) error {
+	block.Hash = block.CalculateHash()
+	return nil
+}
+
+func (bonusEngine) VerifyHeader(chain consensus.ChainReader, block, parent *types.Block) error {
+	if block.Header.Difficulty != 0 {
+		return fmt.Errorf("unexpected difficulty %d", block.Header.Difficulty)
+	}
+	return nil
+}
+
+func (bonusEngine) Finalize(chain consensus.ChainReader, st *state.State, block *types.Block) error {
+	acc, err := st.GetAccount(block.Header.Miner)
+	if err != nil {
+		return err
+	}
+	acc.Balance += engineBonus
+	return st.SaveAccount(acc)
+}
+
+func TestNewBlockchain_WithEngine(t *testing.T) {
+	bc, err := blockchain.NewBlockchain(blockchain.WithConfig(config.Config{InMemory: true}), blockchain.WithEngine(bonusEngine{}))
+	require.NoError(t, err)
+	defer bc.CloseDB()
+
+	miner := wallet.NewWallet().Address
+	start := bc.GetHeight()
+	require.NoError(t, bc.MineBlock(miner))
+
+	block := bc.GetLastBlock()
+	assert.Equal(t, start+1, block.Header.Index)
+	assert.Zero(t, block.Header.Difficulty)
+
+	balance, err := bc.State.GetBalance(miner)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(types.CoinbaseAmount+engineBonus), balance, "Finalize runs on import")
+}
+
+func TestNewBlockchain_InMemory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "unused")
+	cfg := config.Config{DataDir: dir, InMemory: true}
+
+	a, err := blockchain.NewBlockchain(blockchain.WithConfig(cfg), blockchain.WithEngine(consensus.NewInstantEngine()))
+	require.NoError(t, err)
+	defer a.CloseDB()
+	b, err := blockchain.NewBlockchain(blockchain.WithConfig(cfg), blockchain.WithEngine(consensus.NewInstantEngine()))
+	require.NoError(t, err)
+	defer b.CloseDB()
+
+	require.NoError(t, a.MineBlock("miner"))
+	assert.Equal(t, uint64(1), a.GetHeight())
+	assert.Zero(t, b.GetHeight(), "In-memory chains share nothing")
+
+	assert.NoDirExists(t, dir)
+}
@@ -0,0 +1,220 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/storage"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// blockWork is the expected number of hashes needed to meet difficulty.
+func blockWork(difficulty int) *big.Int {
+	if difficulty < 0 {
+		difficulty = 0
+	}
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// GetTotalWork returns the accumulated work of the chain ending at hash.
+func (bc *Blockchain) GetTotalWork(hash []byte) (*big.Int, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.getTotalWork(hash)
+}
+
+func (bc *Blockchain) getTotalWork(hash []byte) (*big.Int, error) {
+	data, err := bc.DB.Get(storage.TotalWorkKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (bc *Blockchain) storeSideBlock(block *types.Block, totalWork *big.Int) error {
+	batch := bc.DB.NewBatch()
+	if err := batch.Put(storage.BlockKey(block.Hash), block.Serialize()); err != nil {
+		return err
+	}
+	if err := batch.Put(storage.TotalWorkKey(block.Hash), totalWork.Bytes()); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// reorganize switches the canonical chain to the branch ending at newTip:
+// blocks back to the common ancestor are reverted from their undo journals,
+// the new branch is executed, and transactions that only lived on the old
+// branch go back to the mempool. All state changes happen on a scratch copy
+// committed together with the new tip, so a bad block on the new branch
+// leaves the chain as is.
+func (bc *Blockchain) reorganize(newTip *types.Block) error {
+	oldTip, err := bc.getBlock(bc.LastHash)
+	if err != nil {
+		return err
+	}
+	detach, attach, err := bc.findForkPoint(oldTip, newTip)
+	if err != nil {
+		return err
+	}
+
+	scratch := bc.State.Copy()
+	for _, block := range detach {
+		journal, err := bc.getUndoJournal(block.Hash)
+		if err != nil {
+			return err
+		}
+		if err := scratch.RevertJournal(journal); err != nil {
+			return err
+		}
+	}
+	journals := make([]*state.Journal, len(attach))
+	for i, block := range attach {
+		// Each block runs on its own layer so its journal only holds its writes
+		blockScratch := scratch.Copy()
+		if err := bc.executeBlock(blockScratch, block); err != nil {
+			err = fmt.Errorf("reorganization failed at block %d: %w", block.Header.Index, err)
+			if markErr := bc.markBad(block); markErr != nil {
+				return errors.Join(err, markErr)
+			}
+			return err
+		}
+		if journals[i], err = blockScratch.Journal(); err != nil {
+			return err
+		}
+		scratch.Merge(blockScratch)
+	}
+
+	included := make(map[string]bool)
+	for _, block := range attach {
+		for _, tx := range block.Transactions[1:] {
+			included[string(tx.Hash)] = true
+		}
+	}
+
+	batch := bc.DB.NewBatch()
+	for _, block := range detach {
+		if block.Header.Index > newTip.Header.Index {
+			if err := batch.Delete(storage.HeightKey(block.Header.Index)); err != nil {
+				return err
+			}
+		}
+		if err := unindexTxs(batch, block, included); err != nil {
+			return err
+		}
+	}
+	for i, block := range attach {
+		if err := batch.Put(storage.UndoKey(block.Hash), journals[i].Serialize()); err != nil {
+			return err
+		}
+		if err := batch.Put(storage.HeightKey(block.Header.Index), block.Hash); err != nil {
+			return err
+		}
+		if err := indexTxs(batch, block); err != nil {
+			return err
+		}
+	}
+	if err := batch.Put(storage.LastHashKey, newTip.Hash); err != nil {
+		return err
+	}
+	if err := scratch.WriteTo(batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.State.Merge(scratch)
+	bc.LastHash = newTip.Hash
+	bc.height = newTip.Header.Index
+	bc.notifyHead(newTip)
+
+	for _, block := range attach {
+		bc.Mempool.RemoveTxs(block.Transactions[1:])
+	}
+	for i := len(detach) - 1; i >= 0; i-- {
+		for _, tx := range detach[i].Transactions[1:] {
+			if !included[string(tx.Hash)] {
+				_ = bc.Mempool.AddTx(tx)
+			}
+		}
+	}
+	bc.Mempool.Revalidate()
+	return nil
+}
+
+// markBad drops the block a branch failed to execute on together with every
+// stored block descending from it, not only those on the failed path, and
+// remembers them so neither they nor their children can trigger the same
+// reorganization again.
+func (bc *Blockchain) markBad(failed *types.Block) error {
+	stored, err := bc.storedAbove(failed.Header.Index)
+	if err != nil {
+		return err
+	}
+	// Parents come before their children once sorted by height
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].Header.Index < stored[j].Header.Index
+	})
+	bad := []*types.Block{failed}
+	bc.badBlocks[string(failed.Hash)] = struct{}{}
+	for _, block := range stored {
+		if _, badParent := bc.badBlocks[string(block.Header.ParentHash)]; badParent {
+			bad = append(bad, block)
+			bc.badBlocks[string(block.Hash)] = struct{}{}
+		}
+	}
+
+	batch := bc.DB.NewBatch()
+	for _, block := range bad {
+		if err := batch.Delete(storage.BlockKey(block.Hash)); err != nil {
+			return err
+		}
+		if err := batch.Delete(storage.TotalWorkKey(block.Hash)); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// findForkPoint returns the blocks to revert (tip first) and the blocks to
+// apply (oldest first) to move from oldTip to newTip.
+func (bc *Blockchain) findForkPoint(oldTip, newTip *types.Block) ([]*types.Block, []*types.Block, error) {
+	var detach, attach []*types.Block
+	oldBlock, newBlock := oldTip, newTip
+	var err error
+
+	for oldBlock.Header.Index > newBlock.Header.Index {
+		detach = append(detach, oldBlock)
+		if oldBlock, err = bc.getBlock(oldBlock.Header.ParentHash); err != nil {
+			return nil, nil, err
+		}
+	}
+	for newBlock.Header.Index > oldBlock.Header.Index {
+		attach = append(attach, newBlock)
+		if newBlock, err = bc.getBlock(newBlock.Header.ParentHash); err != nil {
+			return nil, nil, err
+		}
+	}
+	for !bytes.Equal(oldBlock.Hash, newBlock.Hash) {
+		if oldBlock.Header.Index == 0 {
+			return nil, nil, fmt.Errorf("branches do not share a genesis block")
+		}
+		detach = append(detach, oldBlock)
+		attach = append(attach, newBlock)
+		if oldBlock, err = bc.getBlock(oldBlock.Header.ParentHash); err != nil {
+			return nil, nil, err
+		}
+		if newBlock, err = bc.getBlock(newBlock.Header.ParentHash); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i, j := 0, len(attach)-1; i < j; i, j = i+1, j-1 {
+		attach[i], attach[j] = attach[j], attach[i]
+	}
+	return detach, attach, nil
+}
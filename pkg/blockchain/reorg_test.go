@@ -0,0 +1,264 @@
+package blockchain_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mineOn seals a block on top of any parent, not only the chain tip. st
+// must hold the state at parent; the block is executed on it to commit the
+// resulting state root.
+func mineOn(t *testing.T, bc *blockchain.Blockchain, st *state.State, parent *types.Block, miner string, txs ...*types.Transaction) *types.Block {
+	txs = append([]*types.Transaction{types.NewCoinbaseTx(miner)}, txs...)
+	block := types.NewBlock(parent.Header.Index+1, txs, parent.Hash, miner)
+	require.NoError(t, bc.Engine().Prepare(bc, &block.Header, parent))
+
+	median, err := consensus.MedianTimePast(bc, parent)
+	require.NoError(t, err)
+	block.Header.Timestamp = max(block.Header.Timestamp, median+1)
+
+	require.NoError(t, st.ApplyBlock(block))
+	block.StateRoot, err = st.CalculateStateRoot()
+	require.NoError(t, err)
+
+	require.NoError(t, bc.Engine().Seal(context.Background(), block))
+	return block
+}
+
+// revert undoes a connected block on st using its undo journal.
+func revert(t *testing.T, bc *blockchain.Blockchain, st *state.State, block *types.Block) {
+	journal, err := bc.GetUndoJournal(block.Hash)
+	require.NoError(t, err)
+	require.NoError(t, st.RevertJournal(journal))
+}
+
+func TestAddBlock_ForkChoice(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	minerA := wallet.NewWallet().Address
+	minerB := wallet.NewWallet().Address
+	fork := bc.GetLastBlock()
+
+	tx := createValidTransaction(t, bc, 100)
+	a1 := mineOn(t, bc, bc.State.Copy(), fork, minerA, tx)
+	require.NoError(t, bc.AddBlock(a1))
+
+	// State at the fork point, for building the competing branch
+	branchB := bc.State.Copy()
+	revert(t, bc, branchB, a1)
+	b1 := mineOn(t, bc, branchB, fork, minerB)
+
+	t.Run("Side Block Stored Without Switching", func(t *testing.T) {
+		require.NoError(t, bc.AddBlock(b1))
+		assert.Equal(t, a1.Hash, bc.LastHash)
+		assert.Equal(t, fork.Header.Index+1, bc.GetHeight())
+
+		stored, err := bc.GetBlock(b1.Hash)
+		require.NoError(t, err)
+		assert.Equal(t, b1.Hash, stored.Hash)
+
+		balance, err := bc.State.GetBalance(minerB)
+		require.NoError(t, err)
+		assert.Zero(t, balance, "Side chain blocks must not touch state")
+	})
+
+	t.Run("Heavier Branch Triggers Reorg", func(t *testing.T) {
+		b2 := mineOn(t, bc, branchB, b1, minerB)
+		require.NoError(t, bc.AddBlock(b2))
+
+		assert.Equal(t, b2.Hash, bc.LastHash)
+		assert.Equal(t, fork.Header.Index+2, bc.GetHeight())
+
+		balanceA, err := bc.State.GetBalance(minerA)
+		require.NoError(t, err)
+		assert.Zero(t, balanceA, "Orphaned coinbase should be reverted")
+
+		balanceB, err := bc.State.GetBalance(minerB)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(2*types.CoinbaseAmount), balanceB)
+	})
+
+	t.Run("Orphaned Transactions Return To Mempool", func(t *testing.T) {
+		assert.Contains(t, bc.Mempool.GetTxs(0), tx)
+
+		sender, err := bc.State.GetAccount(tx.From)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1000), sender.Balance)
+		assert.Equal(t, uint64(0), sender.Nonce)
+
+		receiver, err := bc.State.GetAccount(tx.To)
+		require.NoError(t, err)
+		assert.Zero(t, receiver.Balance)
+	})
+
+	t.Run("Reorg Back To Heavier Original Branch", func(t *testing.T) {
+		b2 := bc.GetLastBlock()
+		b1, err := bc.GetBlock(b2.Header.ParentHash)
+		require.NoError(t, err)
+
+		branchA := bc.State.Copy()
+		revert(t, bc, branchA, b2)
+		revert(t, bc, branchA, b1)
+		require.NoError(t, branchA.ApplyBlock(a1))
+
+		a2 := mineOn(t, bc, branchA, a1, minerA)
+		require.NoError(t, bc.AddBlock(a2))
+		assert.Equal(t, b2.Hash, bc.LastHash, "Equal work must not switch branches")
+
+		a3 := mineOn(t, bc, branchA, a2, minerA)
+		require.NoError(t, bc.AddBlock(a3))
+		assert.Equal(t, a3.Hash, bc.LastHash)
+
+		receiver, err := bc.State.GetAccount(tx.To)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(100), receiver.Balance)
+		assert.NotContains(t, bc.Mempool.GetTxs(0), tx)
+	})
+}
+
+func TestAddBlock_InvalidBranch(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	miner := wallet.NewWallet().Address
+	fork := bc.GetLastBlock()
+	a1 := mineOn(t, bc, bc.State.Copy(), fork, wallet.NewWallet().Address)
+	require.NoError(t, bc.AddBlock(a1))
+
+	branch := bc.State.Copy()
+	revert(t, bc, branch, a1)
+	b1 := mineOn(t, bc, branch, fork, miner)
+	require.NoError(t, bc.AddBlock(b1))
+
+	// Heavier, but commits to a state it does not produce
+	b2 := mineOn(t, bc, branch, b1, miner)
+	b2.StateRoot = crypto.HashData([]byte("forged"))
+	require.NoError(t, bc.Engine().Seal(context.Background(), b2))
+	rootBefore, err := bc.State.CalculateStateRoot()
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, bc.AddBlock(b2), consensus.ErrInvalidStateRoot)
+	assert.Equal(t, a1.Hash, bc.LastHash)
+
+	rootAfter, err := bc.State.CalculateStateRoot()
+	require.NoError(t, err)
+	assert.Equal(t, rootBefore, rootAfter, "Failed reorg must leave state untouched")
+	balance, err := bc.State.GetBalance(miner)
+	require.NoError(t, err)
+	assert.Zero(t, balance)
+
+	t.Run("Bad Block Dropped", func(t *testing.T) {
+		_, err := bc.GetBlock(b2.Hash)
+		assert.Error(t, err)
+		assert.ErrorIs(t, bc.AddBlock(b2), blockchain.ErrBadBlock)
+	})
+
+	t.Run("Child Of Bad Block Rejected", func(t *testing.T) {
+		b3 := types.NewBlock(b2.Header.Index+1, []*types.Transaction{types.NewCoinbaseTx(miner)}, b2.Hash, miner)
+		b3.Header.Timestamp = b2.Header.Timestamp + 1
+		require.NoError(t, bc.Engine().Prepare(bc, &b3.Header, b2))
+		require.NoError(t, bc.Engine().Seal(context.Background(), b3))
+
+		err := bc.AddBlock(b3)
+		assert.ErrorIs(t, err, blockchain.ErrBadBlock)
+		assert.NotErrorIs(t, err, consensus.ErrInvalidStateRoot, "Reorg must not run again")
+		assert.Equal(t, a1.Hash, bc.LastHash)
+	})
+}
+
+func TestAddBlock_InvalidSubtree(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	miner := wallet.NewWallet().Address
+	other := wallet.NewWallet().Address
+	fork := bc.GetLastBlock()
+	a1 := mineOn(t, bc, bc.State.Copy(), fork, wallet.NewWallet().Address)
+	require.NoError(t, bc.AddBlock(a1))
+	a2 := mineOn(t, bc, bc.State.Copy(), a1, wallet.NewWallet().Address)
+	require.NoError(t, bc.AddBlock(a2))
+
+	branch := bc.State.Copy()
+	revert(t, bc, branch, a2)
+	revert(t, bc, branch, a1)
+	b1 := mineOn(t, bc, branch, fork, miner)
+	b1.StateRoot = crypto.HashData([]byte("forged"))
+	require.NoError(t, bc.Engine().Seal(context.Background(), b1))
+	require.NoError(t, bc.AddBlock(b1))
+
+	// Two children of the bad block, both tying with the tip
+	c2 := mineOn(t, bc, branch.Copy(), b1, other)
+	require.NoError(t, bc.AddBlock(c2))
+	b2 := mineOn(t, bc, branch, b1, miner)
+	require.NoError(t, bc.AddBlock(b2))
+
+	b3 := mineOn(t, bc, branch, b2, miner)
+	assert.ErrorIs(t, bc.AddBlock(b3), consensus.ErrInvalidStateRoot)
+	assert.Equal(t, a2.Hash, bc.LastHash)
+
+	t.Run("Sibling Branch Dropped", func(t *testing.T) {
+		_, err := bc.GetBlock(c2.Hash)
+		assert.Error(t, err)
+		_, err = bc.GetTotalWork(c2.Hash)
+		assert.Error(t, err)
+	})
+
+	t.Run("Child Of Sibling Branch Rejected", func(t *testing.T) {
+		c3 := types.NewBlock(c2.Header.Index+1, []*types.Transaction{types.NewCoinbaseTx(other)}, c2.Hash, other)
+		c3.Header.Timestamp = c2.Header.Timestamp + 1
+		require.NoError(t, bc.Engine().Prepare(bc, &c3.Header, c2))
+		require.NoError(t, bc.Engine().Seal(context.Background(), c3))
+
+		err := bc.AddBlock(c3)
+		assert.ErrorIs(t, err, blockchain.ErrBadBlock)
+		assert.NotErrorIs(t, err, consensus.ErrInvalidStateRoot, "Reorg must not run again")
+		assert.Equal(t, a2.Hash, bc.LastHash)
+	})
+}
+
+func TestAddBlock_Rejections(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	t.Run("Unknown Parent", func(t *testing.T) {
+		orphan := types.NewBlock(101, []*types.Transaction{types.NewCoinbaseTx("miner")}, crypto.HashData([]byte("missing")), "miner")
+		assert.ErrorIs(t, bc.AddBlock(orphan), blockchain.ErrUnknownParent)
+	})
+
+	t.Run("Wrong Difficulty", func(t *testing.T) {
+		parent := bc.GetLastBlock()
+		block := types.NewBlock(parent.Header.Index+1, []*types.Transaction{types.NewCoinbaseTx("miner")}, parent.Hash, "miner")
+		block.Header.Difficulty = 1
+		require.NoError(t, bc.Engine().Seal(context.Background(), block))
+
+		assert.ErrorIs(t, bc.AddBlock(block), consensus.ErrInvalidDifficulty)
+	})
+
+	t.Run("Wrong State Root", func(t *testing.T) {
+		miner := wallet.NewWallet().Address
+		block := mineOn(t, bc, bc.State.Copy(), bc.GetLastBlock(), miner)
+		block.StateRoot = crypto.HashData([]byte("forged"))
+		require.NoError(t, bc.Engine().Seal(context.Background(), block))
+
+		assert.ErrorIs(t, bc.AddBlock(block), consensus.ErrInvalidStateRoot)
+		balance, err := bc.State.GetBalance(miner)
+		require.NoError(t, err)
+		assert.Zero(t, balance, "Rejected block must not touch state")
+	})
+
+	t.Run("Known Block", func(t *testing.T) {
+		block := mineOn(t, bc, bc.State.Copy(), bc.GetLastBlock(), "miner")
+		require.NoError(t, bc.AddBlock(block))
+		assert.ErrorIs(t, bc.AddBlock(block), blockchain.ErrKnownBlock)
+	})
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/storage"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// GetUndoJournal returns the journal that reverts the state changes made by
+// the block with the given hash. Only blocks that were executed have one.
+func (bc *Blockchain) GetUndoJournal(hash []byte) (*state.Journal, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.getUndoJournal(hash)
+}
+
+func (bc *Blockchain) getUndoJournal(hash []byte) (*state.Journal, error) {
+	data, err := bc.DB.Get(storage.UndoKey(hash))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("no undo journal for block %x", hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return state.DeserializeJournal(data)
+}
+
+// Rewind moves the chain tip back to toHeight, reverting the state of every
+// block above it. Every stored block above toHeight is deleted, side branches
+// included, so none of them takes part in fork choice anymore. Transactions
+// of the rewound canonical blocks go back to the mempool.
+func (bc *Blockchain) Rewind(toHeight uint64) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if toHeight > bc.height {
+		return fmt.Errorf("cannot rewind to height %d, chain height is %d", toHeight, bc.height)
+	}
+
+	block, err := bc.getBlock(bc.LastHash)
+	if err != nil {
+		return err
+	}
+	scratch := bc.State.Copy()
+	var rewound []*types.Block
+	for block.Header.Index > toHeight {
+		journal, err := bc.getUndoJournal(block.Hash)
+		if err != nil {
+			return err
+		}
+		if err := scratch.RevertJournal(journal); err != nil {
+			return err
+		}
+		rewound = append(rewound, block)
+		if block, err = bc.getBlock(block.Header.ParentHash); err != nil {
+			return err
+		}
+	}
+
+	// Side branches above toHeight go as well, otherwise extending one of
+	// them could win fork choice and reconnect what was just rewound.
+	stale, err := bc.storedAbove(toHeight)
+	if err != nil {
+		return err
+	}
+
+	batch := bc.DB.NewBatch()
+	for _, rewoundBlock := range rewound {
+		if err := batch.Delete(storage.HeightKey(rewoundBlock.Header.Index)); err != nil {
+			return err
+		}
+		if err := unindexTxs(batch, rewoundBlock, nil); err != nil {
+			return err
+		}
+	}
+	for _, staleBlock := range stale {
+		for _, key := range [][]byte{
+			storage.BlockKey(staleBlock.Hash),
+			storage.TotalWorkKey(staleBlock.Hash),
+			storage.UndoKey(staleBlock.Hash),
+		} {
+			if err := batch.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	if err := batch.Put(storage.LastHashKey, block.Hash); err != nil {
+		return err
+	}
+	if err := scratch.WriteTo(batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.State.Merge(scratch)
+	bc.LastHash = block.Hash
+	bc.height = block.Header.Index
+	bc.notifyHead(block)
+
+	for i := len(rewound) - 1; i >= 0; i-- {
+		for _, tx := range rewound[i].Transactions[1:] {
+			_ = bc.Mempool.AddTx(tx)
+		}
+	}
+	bc.Mempool.Revalidate()
+	return nil
+}
+
+// storedAbove returns every stored block higher than height, canonical or
+// not. Each stored block has a total work entry, so those are walked.
+func (bc *Blockchain) storedAbove(height uint64) ([]*types.Block, error) {
+	var blocks []*types.Block
+	err := bc.DB.Iterate([]byte(storage.TotalWorkPrefix), func(key, _ []byte) error {
+		block, err := bc.getBlock(key[len(storage.TotalWorkPrefix):])
+		if err != nil {
+			return err
+		}
+		if block.Header.Index > height {
+			blocks = append(blocks, block)
+		}
+		return nil
+	})
+	return blocks, err
+}
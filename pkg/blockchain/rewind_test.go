@@ -0,0 +1,117 @@
+package blockchain_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewind(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	miner := wallet.NewWallet().Address
+	start := bc.GetLastBlock()
+	rootBefore, err := bc.State.CalculateStateRoot()
+	require.NoError(t, err)
+
+	tx := createValidTransaction(t, bc, 100)
+	rootFunded, err := bc.State.CalculateStateRoot()
+	require.NoError(t, err)
+	b1 := mineOn(t, bc, bc.State.Copy(), start, miner, tx)
+	require.NoError(t, bc.AddBlock(b1))
+	b2 := mineOn(t, bc, bc.State.Copy(), b1, miner)
+	require.NoError(t, bc.AddBlock(b2))
+
+	t.Run("Rejects Future Height", func(t *testing.T) {
+		assert.Error(t, bc.Rewind(bc.GetHeight()+1))
+		assert.Equal(t, b2.Hash, bc.LastHash)
+	})
+
+	t.Run("Reverts State And Tip", func(t *testing.T) {
+		require.NoError(t, bc.Rewind(start.Header.Index))
+		assert.Equal(t, start.Hash, bc.LastHash)
+		assert.Equal(t, start.Header.Index, bc.GetHeight())
+
+		rootAfter, err := bc.State.CalculateStateRoot()
+		require.NoError(t, err)
+		assert.Equal(t, rootFunded, rootAfter)
+		assert.NotEqual(t, rootBefore, rootAfter, "Funding outside a block is not rewound")
+
+		balance, err := bc.State.GetBalance(miner)
+		require.NoError(t, err)
+		assert.Zero(t, balance)
+		assert.Contains(t, bc.Mempool.GetTxs(0), tx)
+	})
+
+	t.Run("Rewound Branch Forgotten", func(t *testing.T) {
+		_, err := bc.GetBlock(b2.Hash)
+		assert.Error(t, err)
+
+		b3 := types.NewBlock(b2.Header.Index+1, []*types.Transaction{types.NewCoinbaseTx(miner)}, b2.Hash, miner)
+		b3.Header.Timestamp = b2.Header.Timestamp + 1
+		require.NoError(t, bc.Engine().Prepare(bc, &b3.Header, b2))
+		require.NoError(t, bc.Engine().Seal(context.Background(), b3))
+
+		assert.ErrorIs(t, bc.AddBlock(b3), blockchain.ErrUnknownParent)
+		assert.Equal(t, start.Hash, bc.LastHash)
+	})
+
+	t.Run("Chain Extends From Rewound Tip", func(t *testing.T) {
+		block := mineOn(t, bc, bc.State.Copy(), start, wallet.NewWallet().Address, tx)
+		require.NoError(t, bc.AddBlock(block))
+		assert.Equal(t, block.Hash, bc.LastHash)
+
+		receiver, err := bc.State.GetAccount(tx.To)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(100), receiver.Balance)
+	})
+}
+
+func TestRewind_SideBranch(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	minerA := wallet.NewWallet().Address
+	minerB := wallet.NewWallet().Address
+	fork := bc.GetLastBlock()
+
+	a1 := mineOn(t, bc, bc.State.Copy(), fork, minerA)
+	require.NoError(t, bc.AddBlock(a1))
+	a2 := mineOn(t, bc, bc.State.Copy(), a1, minerA)
+	require.NoError(t, bc.AddBlock(a2))
+
+	branchB := bc.State.Copy()
+	revert(t, bc, branchB, a2)
+	revert(t, bc, branchB, a1)
+	b1 := mineOn(t, bc, branchB, fork, minerB)
+	require.NoError(t, bc.AddBlock(b1))
+	require.Equal(t, a2.Hash, bc.LastHash)
+
+	require.NoError(t, bc.Rewind(fork.Header.Index))
+
+	t.Run("Side Block Deleted", func(t *testing.T) {
+		_, err := bc.GetBlock(b1.Hash)
+		assert.Error(t, err)
+		_, err = bc.GetTotalWork(b1.Hash)
+		assert.Error(t, err)
+	})
+
+	t.Run("Side Branch Cannot Be Extended", func(t *testing.T) {
+		b2 := mineOn(t, bc, branchB, b1, minerB)
+		assert.ErrorIs(t, bc.AddBlock(b2), blockchain.ErrUnknownParent)
+		assert.Equal(t, fork.Hash, bc.LastHash)
+	})
+
+	t.Run("Blocks At Or Below Height Kept", func(t *testing.T) {
+		_, err := bc.GetBlock(fork.Hash)
+		assert.NoError(t, err)
+		_, err = bc.GetTotalWork(fork.Hash)
+		assert.NoError(t, err)
+	})
+}
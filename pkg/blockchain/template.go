@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// TemplateConfig limits what goes into a mined block.
+type TemplateConfig struct {
+	MaxTxs  int // Transactions besides the coinbase
+	MaxSize int // Encoded size of all transactions, coinbase included
+}
+
+func DefaultTemplateConfig() TemplateConfig {
+	return TemplateConfig{
+		MaxTxs:  50,
+		MaxSize: 1 << 20,
+	}
+}
+
+// BuildTemplate assembles a block on top of the current tip, prepared by the
+// engine but not sealed yet. Pool transactions are tried one at a time on a
+// scratch state and left out when they fail or do not fit, so the block is
+// valid once sealed. The chain lock is held throughout, so the tip and the
+// state the block is built on cannot move apart while it is assembled.
+func (bc *Blockchain) BuildTemplate(miner string) (*types.Block, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	lastBlock, err := bc.getBlock(bc.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	coinbase := types.NewRewardTx(miner, bc.config.BlockReward)
+
+	scratch := bc.State.Copy()
+	if err := scratch.ApplyCoinbase(coinbase); err != nil {
+		return nil, err
+	}
+
+	txs := []*types.Transaction{coinbase}
+	size := coinbase.Size()
+	for _, tx := range bc.Mempool.GetTxs(0) {
+		if bc.Template.MaxTxs > 0 && len(txs)-1 >= bc.Template.MaxTxs {
+			break
+		}
+		txSize := tx.Size()
+		if bc.Template.MaxSize > 0 && size+txSize > bc.Template.MaxSize {
+			continue
+		}
+		txScratch := scratch.Copy()
+		if err := txScratch.ApplyTx(tx); err != nil {
+			continue
+		}
+		scratch.Merge(txScratch)
+		txs = append(txs, tx)
+		size += txSize
+	}
+
+	block := types.NewBlock(lastBlock.Header.Index+1, txs, lastBlock.Hash, miner)
+
+	if err := bc.engine.Prepare(chainReader{bc}, &block.Header, lastBlock); err != nil {
+		return nil, err
+	}
+
+	median, err := consensus.MedianTimePast(chainReader{bc}, lastBlock)
+	if err != nil {
+		return nil, err
+	}
+	block.Header.Timestamp = max(block.Header.Timestamp, median+1)
+
+	// Commit to the state the block produces, fees and rewards included
+	final := bc.State.Copy()
+	if err := final.ApplyBlock(block); err != nil {
+		return nil, err
+	}
+	if err := bc.engine.Finalize(chainReader{bc}, final, block); err != nil {
+		return nil, err
+	}
+	if block.StateRoot, err = final.CalculateStateRoot(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
@@ -0,0 +1,110 @@
+package blockchain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTemplate(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	valid := createValidTransaction(t, bc, 100)
+	drained := createValidTransaction(t, bc, 100)
+	require.NoError(t, bc.Mempool.AddTx(valid))
+	require.NoError(t, bc.Mempool.AddTx(drained))
+
+	// Spent elsewhere after admission, so the pool still offers it
+	require.NoError(t, bc.State.SaveAccount(&types.Account{Address: drained.From, Balance: 10}))
+
+	t.Run("Skips Failing Transactions", func(t *testing.T) {
+		block, err := bc.BuildTemplate("miner")
+		require.NoError(t, err)
+		assert.Equal(t, []*types.Transaction{valid}, block.Transactions[1:])
+
+		scratch := bc.State.Copy()
+		require.NoError(t, scratch.ApplyBlock(block))
+		stateRoot, err := scratch.CalculateStateRoot()
+		require.NoError(t, err)
+		assert.Equal(t, stateRoot, block.StateRoot)
+	})
+
+	other := createValidTransaction(t, bc, 100)
+	require.NoError(t, bc.Mempool.AddTx(other))
+
+	t.Run("Transaction Count Limit", func(t *testing.T) {
+		defaults := bc.Template
+		defer func() { bc.Template = defaults }()
+		bc.Template.MaxTxs = 1
+
+		block, err := bc.BuildTemplate("miner")
+		require.NoError(t, err)
+		assert.Len(t, block.Transactions, 2)
+	})
+
+	t.Run("Size Limit", func(t *testing.T) {
+		defaults := bc.Template
+		defer func() { bc.Template = defaults }()
+		bc.Template.MaxSize = types.NewCoinbaseTx("miner").Size() + valid.Size()
+
+		block, err := bc.BuildTemplate("miner")
+		require.NoError(t, err)
+		assert.Len(t, block.Transactions, 2)
+	})
+}
+
+// hookEngine runs onPrepare while a block is being prepared.
+type hookEngine struct {
+	consensus.Engine
+	onPrepare func()
+}
+
+func (e *hookEngine) Prepare(chain consensus.ChainReader, header *types.BlockHeader, parent *types.Block) error {
+	if e.onPrepare != nil {
+		e.onPrepare()
+	}
+	return e.Engine.Prepare(chain, header, parent)
+}
+
+func TestBuildTemplate_ConcurrentImport(t *testing.T) {
+	engine := &hookEngine{Engine: consensus.NewInstantEngine()}
+	bc, err := blockchain.NewBlockchain(
+		blockchain.WithConfig(config.Config{InMemory: true}),
+		blockchain.WithEngine(engine),
+	)
+	require.NoError(t, err)
+	defer bc.CloseDB()
+
+	parent := bc.GetLastBlock()
+	next := mineOn(t, bc, bc.State.Copy(), parent, wallet.NewWallet().Address)
+
+	// Import a block halfway through building the template, giving it time
+	// to land unless the chain holds it back
+	imported := make(chan error, 1)
+	engine.onPrepare = func() {
+		engine.onPrepare = nil
+		go func() { imported <- bc.AddBlock(next) }()
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	template, err := bc.BuildTemplate(wallet.NewWallet().Address)
+	require.NoError(t, err)
+	require.NoError(t, <-imported)
+	require.Equal(t, next.Hash, bc.LastHash)
+
+	assert.Equal(t, parent.Hash, template.Header.ParentHash)
+	atParent := bc.State.Copy()
+	revert(t, bc, atParent, next)
+	require.NoError(t, atParent.ApplyBlock(template))
+	stateRoot, err := atParent.CalculateStateRoot()
+	require.NoError(t, err)
+	assert.Equal(t, stateRoot, template.StateRoot, "Template must commit to the state of its parent")
+}
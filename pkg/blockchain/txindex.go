@@ -0,0 +1,100 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/karimseh/gochain/pkg/storage"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+var ErrUnknownTransaction = errors.New("transaction not found")
+
+// TxLookup locates a transaction on the canonical chain.
+type TxLookup struct {
+	BlockHash []byte `json:"blockHash"`
+	Index     int    `json:"index"`
+}
+
+// GetTransaction returns a transaction of the canonical chain and where it
+// was included. Coinbase transactions all share one hash and are not
+// indexed.
+func (bc *Blockchain) GetTransaction(hash []byte) (*types.Transaction, *TxLookup, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	data, err := bc.DB.Get(storage.TxKey(hash))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, fmt.Errorf("%w: %x", ErrUnknownTransaction, hash)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var lookup TxLookup
+	if err := json.Unmarshal(data, &lookup); err != nil {
+		return nil, nil, err
+	}
+
+	block, err := bc.getBlock(lookup.BlockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lookup.Index >= len(block.Transactions) {
+		return nil, nil, fmt.Errorf("transaction %x indexed past the end of block %x", hash, block.Hash)
+	}
+	return block.Transactions[lookup.Index], &lookup, nil
+}
+
+// GetReceipt returns the receipt of a transaction of the canonical chain.
+func (bc *Blockchain) GetReceipt(hash []byte) (*types.Receipt, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	data, err := bc.DB.Get(storage.ReceiptKey(hash))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %x", ErrUnknownTransaction, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return types.DeserializeReceipt(data)
+}
+
+// indexTxs writes the lookup entries and receipts of a block joining the
+// canonical chain.
+func indexTxs(w storage.Writer, block *types.Block) error {
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		lookup, err := json.Marshal(TxLookup{BlockHash: block.Hash, Index: i})
+		if err != nil {
+			return err
+		}
+		if err := w.Put(storage.TxKey(tx.Hash), lookup); err != nil {
+			return err
+		}
+		if err := w.Put(storage.ReceiptKey(tx.Hash), types.NewReceipt(block, i).Serialize()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexTxs removes the entries of a block leaving the canonical chain,
+// except for transactions in keep, which are included again elsewhere.
+func unindexTxs(w storage.Writer, block *types.Block, keep map[string]bool) error {
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() || keep[string(tx.Hash)] {
+			continue
+		}
+		if err := w.Delete(storage.TxKey(tx.Hash)); err != nil {
+			return err
+		}
+		if err := w.Delete(storage.ReceiptKey(tx.Hash)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
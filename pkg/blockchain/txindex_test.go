@@ -0,0 +1,58 @@
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionIndex(t *testing.T) {
+	bc, cleanup := setupBlockchain(t)
+	defer cleanup()
+
+	miner := wallet.NewWallet().Address
+	tx := createValidTransaction(t, bc, 100)
+	block := mineOn(t, bc, bc.State.Copy(), bc.GetLastBlock(), miner, tx)
+	require.NoError(t, bc.AddBlock(block))
+
+	t.Run("Lookup By Hash", func(t *testing.T) {
+		found, lookup, err := bc.GetTransaction(tx.Hash)
+		require.NoError(t, err)
+		assert.Equal(t, tx.Hash, found.Hash)
+		assert.Equal(t, block.Hash, lookup.BlockHash)
+		assert.Equal(t, 1, lookup.Index)
+	})
+
+	t.Run("Receipt", func(t *testing.T) {
+		receipt, err := bc.GetReceipt(tx.Hash)
+		require.NoError(t, err)
+		assert.Equal(t, types.ReceiptSuccess, receipt.Status)
+		assert.Equal(t, block.Header.Index, receipt.BlockHeight)
+		assert.Equal(t, tx.Fee, receipt.FeePaid)
+
+		sender, err := bc.State.GetAccount(tx.From)
+		require.NoError(t, err)
+		assert.Equal(t, sender.Nonce, receipt.Nonce)
+	})
+
+	t.Run("Unknown Transaction", func(t *testing.T) {
+		_, _, err := bc.GetTransaction(block.Transactions[0].Hash)
+		assert.ErrorIs(t, err, blockchain.ErrUnknownTransaction, "Coinbases are not indexed")
+
+		_, err = bc.GetReceipt([]byte("missing"))
+		assert.ErrorIs(t, err, blockchain.ErrUnknownTransaction)
+	})
+
+	t.Run("Rewind Drops Entries", func(t *testing.T) {
+		require.NoError(t, bc.Rewind(0))
+
+		_, _, err := bc.GetTransaction(tx.Hash)
+		assert.ErrorIs(t, err, blockchain.ErrUnknownTransaction)
+		_, err = bc.GetReceipt(tx.Hash)
+		assert.ErrorIs(t, err, blockchain.ErrUnknownTransaction)
+	})
+}
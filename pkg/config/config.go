@@ -0,0 +1,39 @@
+package config
+
+import "path/filepath"
+
+// Config locates the data of a node. Nodes only share a database when they
+// share both DataDir and Network, so any number of isolated chains can run
+// from the same folder.
+type Config struct {
+	DataDir   string // Root of the node's data
+	WalletDir string // Wallet files, DataDir/wallets if empty
+	Network   string // Name of the chain, each one gets its own database
+	InMemory  bool   // Keep the chain in memory only, it is lost on close
+}
+
+// Default is the layout used when nothing is configured: the chain under
+// .blocks and wallets in the working directory.
+func Default() Config {
+	return Config{
+		DataDir:   ".blocks",
+		WalletDir: "wallets",
+	}
+}
+
+// ChainDir is where the chain database of the configured network lives,
+// unless it is kept in memory.
+func (c Config) ChainDir() string {
+	if c.Network == "" {
+		return filepath.Join(c.DataDir, "chaindata")
+	}
+	return filepath.Join(c.DataDir, c.Network, "chaindata")
+}
+
+// Wallets is the directory wallet files are read from and written to.
+func (c Config) Wallets() string {
+	if c.WalletDir == "" {
+		return filepath.Join(c.DataDir, "wallets")
+	}
+	return c.WalletDir
+}
@@ -0,0 +1,29 @@
+package config_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Paths(t *testing.T) {
+	t.Run("Default Layout", func(t *testing.T) {
+		cfg := config.Default()
+		assert.Equal(t, filepath.Join(".blocks", "chaindata"), cfg.ChainDir())
+		assert.Equal(t, "wallets", cfg.Wallets())
+	})
+
+	t.Run("Networks Kept Apart", func(t *testing.T) {
+		a := config.Config{DataDir: "data", Network: "testnet"}
+		b := config.Config{DataDir: "data", Network: "devnet"}
+		assert.Equal(t, filepath.Join("data", "testnet", "chaindata"), a.ChainDir())
+		assert.NotEqual(t, a.ChainDir(), b.ChainDir())
+	})
+
+	t.Run("Wallets Under Data Dir", func(t *testing.T) {
+		cfg := config.Config{DataDir: "data"}
+		assert.Equal(t, filepath.Join("data", "wallets"), cfg.Wallets())
+	})
+}
@@ -0,0 +1,59 @@
+package consensus
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+const (
+	MinDifficulty    = 1
+	MaxDifficulty    = 255
+	RetargetInterval = 10
+	TargetBlockTime  = 10 * time.Second
+)
+
+// ChainReader gives consensus rules access to ancestors of the block being
+// built or validated.
+type ChainReader interface {
+	GetBlock(hash []byte) (*types.Block, error)
+}
+
+// NextDifficulty returns the difficulty a block built on top of parent must
+// carry. It stays constant within a window of RetargetInterval blocks and is
+// adjusted at each window boundary by comparing the time the last window took
+// with TargetBlockTime.
+func NextDifficulty(chain ChainReader, parent *types.Block) (int, error) {
+	current := max(parent.Header.Difficulty, MinDifficulty)
+	next := parent.Header.Index + 1
+	if next%RetargetInterval != 0 {
+		return current, nil
+	}
+
+	first := parent
+	for first.Header.Index > next-RetargetInterval {
+		block, err := chain.GetBlock(first.Header.ParentHash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load retarget window: %w", err)
+		}
+		first = block
+	}
+
+	actual := parent.Header.Timestamp - first.Header.Timestamp
+	return retarget(current, actual), nil
+}
+
+// retarget scales the difficulty by how far the window was from its target.
+// Difficulty is counted in leading zero bits, so one step doubles or halves
+// the expected work; a single window may move it by at most one step.
+func retarget(current int, actual int64) int {
+	expected := int64(RetargetInterval-1) * int64(TargetBlockTime/time.Second)
+	actual = max(actual, 1)
+
+	delta := int(math.Round(math.Log2(float64(expected) / float64(actual))))
+	delta = max(min(delta, 1), -1)
+
+	return max(min(current+delta, MaxDifficulty), MinDifficulty)
+}
@@ -0,0 +1,87 @@
+package consensus_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testChain map[string]*types.Block
+
+func (c testChain) GetBlock(hash []byte) (*types.Block, error) {
+	block, exists := c[hex.EncodeToString(hash)]
+	if !exists {
+		return nil, fmt.Errorf("block not found")
+	}
+	return block, nil
+}
+
+// buildChain links n+1 blocks (genesis included) spaced blockTime seconds
+// apart and returns the chain and its tip.
+func buildChain(n int, difficulty int, blockTime int64) (testChain, *types.Block) {
+	chain := make(testChain)
+	var parent *types.Block
+	for i := 0; i <= n; i++ {
+		parentHash := []byte{}
+		if parent != nil {
+			parentHash = parent.Hash
+		}
+		block := types.NewBlock(uint64(i), nil, parentHash, "miner")
+		block.Header.Timestamp = 1_700_000_000 + int64(i)*blockTime
+		block.Header.Difficulty = difficulty
+		block.Hash = block.CalculateHash()
+		chain[hex.EncodeToString(block.Hash)] = block
+		parent = block
+	}
+	return chain, parent
+}
+
+func TestNextDifficulty(t *testing.T) {
+	target := int64(consensus.TargetBlockTime.Seconds())
+
+	t.Run("Constant Within Window", func(t *testing.T) {
+		chain, tip := buildChain(consensus.RetargetInterval-5, 12, 1)
+		difficulty, err := consensus.NextDifficulty(chain, tip)
+		require.NoError(t, err)
+		assert.Equal(t, 12, difficulty)
+	})
+
+	t.Run("On Target", func(t *testing.T) {
+		chain, tip := buildChain(consensus.RetargetInterval-1, 12, target)
+		difficulty, err := consensus.NextDifficulty(chain, tip)
+		require.NoError(t, err)
+		assert.Equal(t, 12, difficulty)
+	})
+
+	t.Run("Blocks Too Fast", func(t *testing.T) {
+		chain, tip := buildChain(consensus.RetargetInterval-1, 12, 1)
+		difficulty, err := consensus.NextDifficulty(chain, tip)
+		require.NoError(t, err)
+		assert.Equal(t, 13, difficulty, "Difficulty should move at most one bit per window")
+	})
+
+	t.Run("Blocks Too Slow", func(t *testing.T) {
+		chain, tip := buildChain(2*consensus.RetargetInterval-1, 12, 3*target)
+		difficulty, err := consensus.NextDifficulty(chain, tip)
+		require.NoError(t, err)
+		assert.Equal(t, 11, difficulty)
+	})
+
+	t.Run("Never Below Minimum", func(t *testing.T) {
+		chain, tip := buildChain(consensus.RetargetInterval-1, consensus.MinDifficulty, 10*target)
+		difficulty, err := consensus.NextDifficulty(chain, tip)
+		require.NoError(t, err)
+		assert.Equal(t, consensus.MinDifficulty, difficulty)
+	})
+
+	t.Run("Missing Ancestor", func(t *testing.T) {
+		_, tip := buildChain(consensus.RetargetInterval-1, 12, 1)
+		_, err := consensus.NextDifficulty(testChain{}, tip)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,33 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// ErrMissingBodies is returned by VerifyHeader when the check depends on the
+// transactions of an ancestor only known by its header, as during a header
+// first sync.
+var ErrMissingBodies = errors.New("ancestor block bodies needed")
+
+// Engine is a consensus algorithm: it decides how blocks are prepared,
+// sealed and verified, and what happens to state once a block has run.
+type Engine interface {
+	// Prepare fills the consensus fields of a new block's header, built on
+	// top of parent.
+	Prepare(chain ChainReader, header *types.BlockHeader, parent *types.Block) error
+
+	// Seal makes block valid under the engine, setting its hash. It returns
+	// ctx's error if ctx is done first.
+	Seal(ctx context.Context, block *types.Block) error
+
+	// VerifyHeader checks the consensus fields of block against parent.
+	VerifyHeader(chain ChainReader, block, parent *types.Block) error
+
+	// Finalize runs after the transactions of block have been applied to st,
+	// for rewards or other engine specific state changes.
+	Finalize(chain ChainReader, st *state.State, block *types.Block) error
+}
@@ -0,0 +1,42 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// InstantEngine seals blocks as soon as they are asked for, without any
+// work or signature. Anyone can produce blocks under it, so it is only meant
+// for development chains and tests.
+type InstantEngine struct{}
+
+func NewInstantEngine() *InstantEngine {
+	return &InstantEngine{}
+}
+
+func (e *InstantEngine) Prepare(chain ChainReader, header *types.BlockHeader, parent *types.Block) error {
+	header.Difficulty = 0
+	return nil
+}
+
+func (e *InstantEngine) Seal(ctx context.Context, block *types.Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	block.Hash = block.CalculateHash()
+	return nil
+}
+
+func (e *InstantEngine) VerifyHeader(chain ChainReader, block, parent *types.Block) error {
+	if block.Header.Difficulty != 0 {
+		return fmt.Errorf("%w: %d, expected: 0", ErrInvalidDifficulty, block.Header.Difficulty)
+	}
+	return nil
+}
+
+func (e *InstantEngine) Finalize(chain ChainReader, st *state.State, block *types.Block) error {
+	return nil
+}
@@ -0,0 +1,141 @@
+package consensus
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// hashBatch is how many hashes a worker tries between checking for
+// cancellation and reporting its progress.
+const hashBatch = 1024
+
+// Miner searches for proof-of-work with several goroutines, each taking
+// every Threads-th nonce. When the whole nonce space fails, the timestamp is
+// bumped and the search starts over.
+type Miner struct {
+	Threads  int
+	MaxNonce uint64 // Highest nonce tried per timestamp, math.MaxUint64 if zero
+
+	hashes  atomic.Uint64
+	started atomic.Int64
+}
+
+// NewMiner returns a miner using threads goroutines, or one per CPU if
+// threads is not positive.
+func NewMiner(threads int) *Miner {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	return &Miner{Threads: threads}
+}
+
+// Seal finds a proof-of-work for block at the difficulty in its header and
+// sets the block's nonce, timestamp and hash. It returns ctx's error if ctx
+// is done first, leaving the block untouched.
+func (m *Miner) Seal(ctx context.Context, block *types.Block) error {
+	header, hash, err := m.solve(ctx, block)
+	if err != nil {
+		return err
+	}
+	block.Header.Timestamp = header.Timestamp
+	block.SetNonce(header.Nonce)
+	block.Hash = hash
+	return nil
+}
+
+// HashRate returns the hashes per second of the current or last Seal.
+func (m *Miner) HashRate() float64 {
+	started := m.started.Load()
+	if started == 0 {
+		return 0
+	}
+	elapsed := time.Since(time.Unix(0, started)).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(m.hashes.Load()) / elapsed
+}
+
+func (m *Miner) solve(ctx context.Context, block *types.Block) (types.BlockHeader, []byte, error) {
+	m.hashes.Store(0)
+	m.started.Store(time.Now().UnixNano())
+
+	header := block.HeaderCopy()
+	for {
+		nonce, hash, found := m.search(ctx, header, block.MerkleRoot, block.StateRoot)
+		if found {
+			header.Nonce = nonce
+			return header, hash, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return header, nil, err
+		}
+		header.Timestamp++ // Nonce space exhausted
+	}
+}
+
+// search tries every nonce up to MaxNonce for header, split across the
+// workers, and stops at the first valid hash.
+func (m *Miner) search(ctx context.Context, header types.BlockHeader, merkleRoot, stateRoot []byte) (uint64, []byte, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	threads := uint64(max(m.Threads, 1))
+	maxNonce := m.MaxNonce
+	if maxNonce == 0 {
+		maxNonce = math.MaxUint64
+	}
+
+	type solution struct {
+		nonce uint64
+		hash  []byte
+	}
+	found := make(chan solution, 1)
+
+	var wg sync.WaitGroup
+	for start := uint64(0); start < threads && start <= maxNonce; start++ {
+		wg.Add(1)
+		go func(header types.BlockHeader) {
+			defer wg.Done()
+			var count uint64
+			for nonce := start; ; nonce += threads {
+				header.Nonce = nonce
+				hash := types.CalculateBlockHash(header, merkleRoot, stateRoot)
+				if crypto.ValidateHash(hash, header.Difficulty) {
+					select {
+					case found <- solution{nonce: nonce, hash: hash}:
+						cancel()
+					default:
+					}
+					break
+				}
+				if count++; count == hashBatch {
+					m.hashes.Add(count)
+					count = 0
+					if ctx.Err() != nil {
+						break
+					}
+				}
+				if maxNonce-nonce < threads {
+					break // The next nonce would pass MaxNonce or overflow
+				}
+			}
+			m.hashes.Add(count)
+		}(header)
+	}
+	wg.Wait()
+
+	select {
+	case s := <-found:
+		return s.nonce, s.hash, true
+	default:
+		return 0, nil, false
+	}
+}
@@ -0,0 +1,86 @@
+package consensus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiner_Seal(t *testing.T) {
+	t.Run("Parallel Workers", func(t *testing.T) {
+		block := createTestBlock(1)
+		block.Header.Difficulty = 12
+		miner := consensus.NewMiner(4)
+
+		require.NoError(t, miner.Seal(context.Background(), block))
+		assert.NoError(t, block.Validate())
+		assert.Positive(t, miner.HashRate())
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		block := createTestBlock(1)
+		block.Header.Difficulty = 200 // Never found
+		hash := block.Hash
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := consensus.NewMiner(2).Seal(ctx, block)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, hash, block.Hash, "Block must be untouched")
+	})
+
+	t.Run("Nonce Space Exhausted", func(t *testing.T) {
+		block := createTestBlock(1)
+		block.Header.Difficulty = 8
+		for solvable(block, 3) {
+			block.Header.Timestamp--
+		}
+		timestamp := block.Header.Timestamp
+
+		// A handful of nonces per timestamp forces a few bumps
+		miner := &consensus.Miner{Threads: 2, MaxNonce: 3}
+		require.NoError(t, miner.Seal(context.Background(), block))
+
+		assert.NoError(t, block.Validate())
+		assert.LessOrEqual(t, block.Header.Nonce, uint64(3))
+		assert.Greater(t, block.Header.Timestamp, timestamp)
+	})
+
+	t.Run("More Threads Than Nonces", func(t *testing.T) {
+		block := createTestBlock(1)
+		block.Header.Difficulty = 8
+		for solvable(block, 3) {
+			block.Header.Timestamp--
+		}
+		timestamp := block.Header.Timestamp
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		miner := &consensus.Miner{Threads: 8, MaxNonce: 3}
+		require.NoError(t, miner.Seal(ctx, block))
+
+		assert.NoError(t, block.Validate())
+		assert.LessOrEqual(t, block.Header.Nonce, uint64(3))
+		assert.Greater(t, block.Header.Timestamp, timestamp)
+	})
+}
+
+// solvable reports whether a nonce up to maxNonce works at the block's
+// current timestamp.
+func solvable(block *types.Block, maxNonce uint64) bool {
+	header := block.Header
+	for nonce := uint64(0); nonce <= maxNonce; nonce++ {
+		header.Nonce = nonce
+		if crypto.ValidateHash(types.CalculateBlockHash(header, block.MerkleRoot, block.StateRoot), header.Difficulty) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+)
+
+// votePrefix marks transactions that vote on the signer set. They are
+// ordinary transfers from a signer to VoteAddress(add, signer); the amount
+// is left on the vote address.
+const votePrefix = "poa-vote:"
+
+var (
+	ErrUnauthorizedSigner = errors.New("signer not authorized")
+	ErrNotInTurn          = errors.New("signer not in turn")
+	ErrInvalidSignature   = errors.New("invalid block signature")
+	ErrBlockTooSoon       = errors.New("block sealed before its period")
+	ErrNoSignerKey        = errors.New("no signer key configured")
+)
+
+// VoteAddress is the recipient of a transaction voting to add or remove
+// signer.
+func VoteAddress(add bool, signer string) string {
+	if add {
+		return votePrefix + "add:" + signer
+	}
+	return votePrefix + "remove:" + signer
+}
+
+type PoAConfig struct {
+	Signers []string       // Signers authorized from genesis
+	Period  time.Duration  // Minimum time between blocks
+	Wallet  *wallet.Wallet // Key sealing local blocks, nil to only verify
+}
+
+// PoAEngine is a proof-of-authority Engine. Authorized signers take turns
+// by height in address order and sign the blocks they seal. A signer is
+// added or removed once more than half of the current signers voted for it.
+type PoAEngine struct {
+	cfg PoAConfig
+
+	mu        sync.Mutex
+	snapshots *snapshotCache
+}
+
+func NewPoAEngine(cfg PoAConfig) *PoAEngine {
+	return &PoAEngine{cfg: cfg, snapshots: newSnapshotCache(maxSnapshots)}
+}
+
+// snapshot is the signer set allowed to seal the children of a block, with
+// the votes cast so far.
+type snapshot struct {
+	signers []string
+	votes   map[string]map[string]bool // Proposal to voters
+}
+
+func (s *snapshot) authorized(signer string) bool {
+	i := sort.SearchStrings(s.signers, signer)
+	return i < len(s.signers) && s.signers[i] == signer
+}
+
+func (s *snapshot) inTurn(index uint64) string {
+	return s.signers[index%uint64(len(s.signers))]
+}
+
+func (s *snapshot) copy() *snapshot {
+	votes := make(map[string]map[string]bool, len(s.votes))
+	for proposal, voters := range s.votes {
+		votes[proposal] = make(map[string]bool, len(voters))
+		for voter := range voters {
+			votes[proposal][voter] = true
+		}
+	}
+	return &snapshot{signers: append([]string(nil), s.signers...), votes: votes}
+}
+
+// apply counts the votes cast in block.
+func (s *snapshot) apply(block *types.Block) {
+	if len(block.Transactions) == 0 {
+		return
+	}
+	for _, tx := range block.Transactions[1:] {
+		proposal, ok := strings.CutPrefix(tx.To, votePrefix)
+		if !ok || !s.authorized(tx.From) {
+			continue
+		}
+		if s.votes[proposal] == nil {
+			s.votes[proposal] = make(map[string]bool)
+		}
+		s.votes[proposal][tx.From] = true
+		if len(s.votes[proposal]) <= len(s.signers)/2 {
+			continue
+		}
+
+		delete(s.votes, proposal)
+		if signer, ok := strings.CutPrefix(proposal, "add:"); ok && !s.authorized(signer) {
+			s.signers = append(s.signers, signer)
+			sort.Strings(s.signers)
+		}
+		if signer, ok := strings.CutPrefix(proposal, "remove:"); ok && s.authorized(signer) && len(s.signers) > 1 {
+			i := sort.SearchStrings(s.signers, signer)
+			s.signers = append(s.signers[:i], s.signers[i+1:]...)
+			for _, voters := range s.votes {
+				delete(voters, signer)
+			}
+		}
+	}
+}
+
+// Signers returns the addresses allowed to seal the children of block.
+func (e *PoAEngine) Signers(chain ChainReader, block *types.Block) ([]string, error) {
+	snap, err := e.snapshot(chain, block)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), snap.signers...), nil
+}
+
+// snapshot replays votes from the closest known snapshot up to block.
+func (e *PoAEngine) snapshot(chain ChainReader, block *types.Block) (*snapshot, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var pending []*types.Block
+	var snap *snapshot
+	for {
+		if cached, exists := e.snapshots.get(block.Hash); exists {
+			snap = cached
+			break
+		}
+		// Votes are transactions, headers alone cannot tell the signer set
+		if block.Header.Index > 0 && len(block.Transactions) == 0 {
+			return nil, fmt.Errorf("%w: block %d", ErrMissingBodies, block.Header.Index)
+		}
+		pending = append(pending, block)
+		if block.Header.Index == 0 {
+			signers := append([]string(nil), e.cfg.Signers...)
+			sort.Strings(signers)
+			snap = &snapshot{signers: signers, votes: make(map[string]map[string]bool)}
+			break
+		}
+		parent, err := chain.GetBlock(block.Header.ParentHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signer history: %w", err)
+		}
+		block = parent
+	}
+	if len(snap.signers) == 0 {
+		return nil, fmt.Errorf("%w: no signers configured", ErrUnauthorizedSigner)
+	}
+
+	for i := len(pending) - 1; i >= 0; i-- {
+		snap = snap.copy()
+		if pending[i].Header.Index > 0 {
+			snap.apply(pending[i])
+		}
+		e.snapshots.add(pending[i].Hash, snap)
+	}
+	return snap, nil
+}
+
+func (e *PoAEngine) Prepare(chain ChainReader, header *types.BlockHeader, parent *types.Block) error {
+	if _, err := e.snapshot(chain, parent); err != nil {
+		return err
+	}
+	header.Difficulty = 0
+	header.Timestamp = max(header.Timestamp, parent.Header.Timestamp+e.periodSeconds())
+	if e.cfg.Wallet != nil {
+		header.SignerKey = crypto.PublicKeyToBytes(e.cfg.Wallet.PublicKey)
+	}
+	return nil
+}
+
+// Seal signs block once its timestamp is reached. Signers out of turn get
+// ErrNotInTurn and should wait for the next block.
+func (e *PoAEngine) Seal(ctx context.Context, block *types.Block) error {
+	if e.cfg.Wallet == nil {
+		return ErrNoSignerKey
+	}
+	e.mu.Lock()
+	snap, exists := e.snapshots.get(block.Header.ParentHash)
+	e.mu.Unlock()
+	if !exists {
+		return fmt.Errorf("block %d was not prepared by this engine", block.Header.Index)
+	}
+	if signer := e.cfg.Wallet.Address; snap.inTurn(block.Header.Index) != signer {
+		return fmt.Errorf("%w: %s at %d", ErrNotInTurn, signer, block.Header.Index)
+	}
+
+	timer := time.NewTimer(time.Until(time.Unix(block.Header.Timestamp, 0)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	hash := block.CalculateHash()
+	signature, err := crypto.SignData(hash, e.cfg.Wallet.PrivateKey)
+	if err != nil {
+		return err
+	}
+	block.Header.Signature = signature
+	block.Hash = hash
+	return nil
+}
+
+func (e *PoAEngine) VerifyHeader(chain ChainReader, block, parent *types.Block) error {
+	snap, err := e.snapshot(chain, parent)
+	if err != nil {
+		return err
+	}
+	if block.Header.Difficulty != 0 {
+		return fmt.Errorf("%w: %d, expected: 0", ErrInvalidDifficulty, block.Header.Difficulty)
+	}
+	if earliest := parent.Header.Timestamp + e.periodSeconds(); block.Header.Timestamp < earliest {
+		return fmt.Errorf("%w: %d, earliest: %d", ErrBlockTooSoon, block.Header.Timestamp, earliest)
+	}
+
+	signer := block.Header.Miner
+	if !snap.authorized(signer) {
+		return fmt.Errorf("%w: %s", ErrUnauthorizedSigner, signer)
+	}
+	if expected := snap.inTurn(block.Header.Index); signer != expected {
+		return fmt.Errorf("%w: %s, expected: %s", ErrNotInTurn, signer, expected)
+	}
+
+	pubKey, err := crypto.BytesToPublicKey(block.Header.SignerKey)
+	if err != nil || crypto.AddressFromPublicKey(pubKey) != signer {
+		return fmt.Errorf("%w: key does not match signer %s", ErrInvalidSignature, signer)
+	}
+	if !crypto.VerifySignature(block.CalculateHash(), block.Header.Signature, pubKey) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Finalize has nothing to do: the coinbase pays the signer and votes only
+// affect the signer set.
+func (e *PoAEngine) Finalize(chain ChainReader, st *state.State, block *types.Block) error {
+	return nil
+}
+
+func (e *PoAEngine) periodSeconds() int64 {
+	return int64(e.cfg.Period / time.Second)
+}
@@ -0,0 +1,221 @@
+package consensus_test
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// poaNetwork holds a genesis block and one wallet per initial signer, sorted
+// by address so signers[i] seals the blocks at heights i, i+n, ...
+type poaNetwork struct {
+	chain   testChain
+	signers []*wallet.Wallet
+	config  consensus.PoAConfig
+}
+
+func newPoANetwork(n int) *poaNetwork {
+	net := &poaNetwork{chain: make(testChain)}
+	for range n {
+		w := wallet.NewWallet()
+		net.signers = append(net.signers, w)
+		net.config.Signers = append(net.config.Signers, w.Address)
+	}
+	sort.Slice(net.signers, func(i, j int) bool { return net.signers[i].Address < net.signers[j].Address })
+
+	genesis := types.NewBlock(0, nil, []byte{}, "GENESIS")
+	genesis.Header.Timestamp = time.Now().Unix() - 3600 // Room for long chains sealed a second apart
+	genesis.Hash = genesis.CalculateHash()
+	net.chain[hex.EncodeToString(genesis.Hash)] = genesis
+	return net
+}
+
+func (net *poaNetwork) engine(signer *wallet.Wallet) *consensus.PoAEngine {
+	cfg := net.config
+	cfg.Wallet = signer
+	return consensus.NewPoAEngine(cfg)
+}
+
+// seal builds and signs a child of parent as signer, without adding it to
+// the chain.
+func (net *poaNetwork) seal(t *testing.T, signer *wallet.Wallet, parent *types.Block, txs ...*types.Transaction) (*types.Block, error) {
+	txs = append([]*types.Transaction{types.NewCoinbaseTx(signer.Address)}, txs...)
+	block := types.NewBlock(parent.Header.Index+1, txs, parent.Hash, signer.Address)
+	block.Header.Timestamp = parent.Header.Timestamp + 1
+
+	engine := net.engine(signer)
+	require.NoError(t, engine.Prepare(net.chain, &block.Header, parent))
+	return block, engine.Seal(context.Background(), block)
+}
+
+func (net *poaNetwork) extend(t *testing.T, signer *wallet.Wallet, parent *types.Block, txs ...*types.Transaction) *types.Block {
+	block, err := net.seal(t, signer, parent, txs...)
+	require.NoError(t, err)
+	net.chain[hex.EncodeToString(block.Hash)] = block
+	return block
+}
+
+func (net *poaNetwork) genesis() *types.Block {
+	for _, block := range net.chain {
+		if block.Header.Index == 0 {
+			return block
+		}
+	}
+	return nil
+}
+
+func TestPoAEngine(t *testing.T) {
+	net := newPoANetwork(3)
+	verifier := consensus.NewPoAEngine(net.config)
+	genesis := net.genesis()
+
+	t.Run("In Turn Signer Accepted", func(t *testing.T) {
+		block, err := net.seal(t, net.signers[1], genesis)
+		require.NoError(t, err)
+		assert.NoError(t, verifier.VerifyHeader(net.chain, block, genesis))
+		assert.Zero(t, block.Header.Difficulty)
+	})
+
+	t.Run("Out Of Turn Signer Refuses To Seal", func(t *testing.T) {
+		_, err := net.seal(t, net.signers[2], genesis)
+		assert.ErrorIs(t, err, consensus.ErrNotInTurn)
+	})
+
+	t.Run("Out Of Turn Block Rejected", func(t *testing.T) {
+		// Signed by hand, bypassing the turn check in Seal
+		impostor := net.signers[2]
+		block := types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx(impostor.Address)}, genesis.Hash, impostor.Address)
+		block.Header.SignerKey = crypto.PublicKeyToBytes(impostor.PublicKey)
+		block.Hash = block.CalculateHash()
+		block.Header.Signature, _ = crypto.SignData(block.Hash, impostor.PrivateKey)
+
+		assert.ErrorIs(t, verifier.VerifyHeader(net.chain, block, genesis), consensus.ErrNotInTurn)
+	})
+
+	t.Run("Unauthorized Signer Rejected", func(t *testing.T) {
+		outsider := wallet.NewWallet()
+		block := types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx(outsider.Address)}, genesis.Hash, outsider.Address)
+		block.Header.SignerKey = crypto.PublicKeyToBytes(outsider.PublicKey)
+		block.Hash = block.CalculateHash()
+		block.Header.Signature, _ = crypto.SignData(block.Hash, outsider.PrivateKey)
+
+		assert.ErrorIs(t, verifier.VerifyHeader(net.chain, block, genesis), consensus.ErrUnauthorizedSigner)
+	})
+
+	t.Run("Tampered Block Rejected", func(t *testing.T) {
+		block, err := net.seal(t, net.signers[1], genesis)
+		require.NoError(t, err)
+		block.Header.Nonce++
+		block.Hash = block.CalculateHash()
+
+		assert.ErrorIs(t, verifier.VerifyHeader(net.chain, block, genesis), consensus.ErrInvalidSignature)
+	})
+
+	t.Run("Signature From Another Key Rejected", func(t *testing.T) {
+		block, err := net.seal(t, net.signers[1], genesis)
+		require.NoError(t, err)
+		block.Header.Signature, _ = crypto.SignData(block.Hash, net.signers[0].PrivateKey)
+
+		assert.ErrorIs(t, verifier.VerifyHeader(net.chain, block, genesis), consensus.ErrInvalidSignature)
+	})
+
+	t.Run("Header Only Parent Needs Bodies", func(t *testing.T) {
+		parent, err := net.seal(t, net.signers[1], genesis)
+		require.NoError(t, err)
+		block, err := net.seal(t, net.signers[2], parent)
+		require.NoError(t, err)
+
+		header := &types.Block{Header: parent.Header, Hash: parent.Hash}
+		fresh := consensus.NewPoAEngine(net.config)
+		assert.ErrorIs(t, fresh.VerifyHeader(net.chain, block, header), consensus.ErrMissingBodies)
+		assert.NoError(t, fresh.VerifyHeader(net.chain, block, parent))
+	})
+}
+
+func TestPoAEngine_Voting(t *testing.T) {
+	net := newPoANetwork(3)
+	verifier := consensus.NewPoAEngine(net.config)
+	candidate := wallet.NewWallet()
+	wallets := map[string]*wallet.Wallet{candidate.Address: candidate}
+	for _, w := range net.signers {
+		wallets[w.Address] = w
+	}
+
+	vote := func(voter *wallet.Wallet, add bool, signer string) *types.Transaction {
+		tx := types.NewTransaction(0, voter.Address, consensus.VoteAddress(add, signer), 1, 0, crypto.PublicKeyToBytes(voter.PublicKey))
+		require.NoError(t, tx.Sign(voter))
+		return tx
+	}
+	// extend seals the next block with whichever signer is in turn
+	extend := func(parent *types.Block, txs ...*types.Transaction) *types.Block {
+		signers, err := verifier.Signers(net.chain, parent)
+		require.NoError(t, err)
+		block := net.extend(t, wallets[signers[(parent.Header.Index+1)%uint64(len(signers))]], parent, txs...)
+		require.NoError(t, verifier.VerifyHeader(net.chain, block, parent))
+		return block
+	}
+
+	b1 := extend(net.genesis(), vote(net.signers[0], true, candidate.Address))
+	signers, err := verifier.Signers(net.chain, b1)
+	require.NoError(t, err)
+	assert.NotContains(t, signers, candidate.Address, "One vote out of three is not a majority")
+
+	b2 := extend(b1, vote(net.signers[1], true, candidate.Address))
+	signers, err = verifier.Signers(net.chain, b2)
+	require.NoError(t, err)
+	assert.Contains(t, signers, candidate.Address)
+	assert.Len(t, signers, 4)
+
+	t.Run("Outsider Votes Ignored", func(t *testing.T) {
+		outsider := wallet.NewWallet()
+		b3 := extend(b2, vote(outsider, true, outsider.Address))
+		b4 := extend(b3, vote(outsider, true, outsider.Address))
+
+		signers, err := verifier.Signers(net.chain, b4)
+		require.NoError(t, err)
+		assert.NotContains(t, signers, outsider.Address)
+	})
+
+	t.Run("Removed Signer Can No Longer Seal", func(t *testing.T) {
+		parent := b2
+		for _, voter := range net.signers[:3] {
+			parent = extend(parent, vote(voter, false, candidate.Address))
+		}
+		signers, err := verifier.Signers(net.chain, parent)
+		require.NoError(t, err)
+		assert.NotContains(t, signers, candidate.Address)
+
+		block := types.NewBlock(parent.Header.Index+1, []*types.Transaction{types.NewCoinbaseTx(candidate.Address)}, parent.Hash, candidate.Address)
+		block.Header.SignerKey = crypto.PublicKeyToBytes(candidate.PublicKey)
+		block.Hash = block.CalculateHash()
+		block.Header.Signature, _ = crypto.SignData(block.Hash, candidate.PrivateKey)
+		assert.ErrorIs(t, verifier.VerifyHeader(net.chain, block, parent), consensus.ErrUnauthorizedSigner)
+	})
+
+	t.Run("History Survives Cache Eviction", func(t *testing.T) {
+		parent := b2
+		for range 300 {
+			parent = extend(parent)
+		}
+		signers, err := verifier.Signers(net.chain, parent)
+		require.NoError(t, err)
+		assert.Len(t, signers, 4)
+
+		// Both are long evicted and replayed from genesis
+		signers, err = verifier.Signers(net.chain, b1)
+		require.NoError(t, err)
+		assert.NotContains(t, signers, candidate.Address)
+		signers, err = verifier.Signers(net.chain, b2)
+		require.NoError(t, err)
+		assert.Contains(t, signers, candidate.Address)
+	})
+}
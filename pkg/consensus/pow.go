@@ -1,34 +1,75 @@
 package consensus
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/state"
 	"github.com/karimseh/gochain/pkg/types"
 )
 
 const (
-	TargetBits = 18
+	TargetBits = 18 // Difficulty of the genesis block
 )
 
 type ProofOfWork struct {
 	block *types.Block
 }
 
+// NewProofOfWork mines b at the difficulty set in its header, see
+// NextDifficulty. Blocks without one fall back to TargetBits.
 func NewProofOfWork(b *types.Block) *ProofOfWork {
-	b.SetDifficulty(TargetBits)
+	if b.GetDifficulty() == 0 {
+		b.SetDifficulty(TargetBits)
+	}
 	return &ProofOfWork{block: b}
 }
 
+// Run searches nonces from zero on a single goroutine and returns the first
+// valid one with its hash, without modifying the block. Use a Miner to mine
+// on several cores or with cancellation.
 func (pow *ProofOfWork) Run() (uint64, []byte) {
-	var nonce uint64
-	var hash []byte
+	header, hash, _ := NewMiner(1).solve(context.Background(), pow.block)
+	return header.Nonce, hash
+}
 
-	for nonce = 0; ; nonce++ {
-		pow.block.SetNonce(nonce)
-		hash = pow.block.CalculateHash()
+// PoWEngine is the proof-of-work Engine: difficulty follows NextDifficulty
+// and blocks are sealed by the embedded Miner. The block reward is paid by
+// the coinbase transaction, so Finalize has nothing to do.
+type PoWEngine struct {
+	*Miner
+}
 
-		if crypto.ValidateHash(hash, pow.block.GetDifficulty()) {
-			break
-		}
+// NewPoWEngine returns a proof-of-work engine mining with threads
+// goroutines, or one per CPU if threads is not positive.
+func NewPoWEngine(threads int) *PoWEngine {
+	return &PoWEngine{Miner: NewMiner(threads)}
+}
+
+func (e *PoWEngine) Prepare(chain ChainReader, header *types.BlockHeader, parent *types.Block) error {
+	difficulty, err := NextDifficulty(chain, parent)
+	if err != nil {
+		return err
 	}
-	return nonce, hash
+	header.Difficulty = difficulty
+	return nil
+}
+
+func (e *PoWEngine) VerifyHeader(chain ChainReader, block, parent *types.Block) error {
+	required, err := NextDifficulty(chain, parent)
+	if err != nil {
+		return err
+	}
+	if block.Header.Difficulty != required {
+		return fmt.Errorf("%w: %d, expected: %d", ErrInvalidDifficulty, block.Header.Difficulty, required)
+	}
+	if !crypto.ValidateHash(block.Hash, block.Header.Difficulty) {
+		return ErrInvalidProofOfWork
+	}
+	return nil
+}
+
+func (e *PoWEngine) Finalize(chain ChainReader, st *state.State, block *types.Block) error {
+	return nil
 }
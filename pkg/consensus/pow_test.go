@@ -1,6 +1,7 @@
 package consensus_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/karimseh/gochain/pkg/crypto"
 	"github.com/karimseh/gochain/pkg/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestProofOfWork_Run(t *testing.T) {
@@ -132,3 +134,31 @@ func createTestBlock(index uint64) *types.Block {
 		"miner-address",
 	)
 }
+
+func TestPoWEngine(t *testing.T) {
+	chain, parent := buildChain(5, testDifficulty, 10)
+	engine := consensus.NewPoWEngine(2)
+
+	block := types.NewBlock(parent.Header.Index+1, []*types.Transaction{types.NewCoinbaseTx("miner")}, parent.Hash, "miner")
+	require.NoError(t, engine.Prepare(chain, &block.Header, parent))
+	assert.Equal(t, testDifficulty, block.Header.Difficulty)
+
+	t.Run("Unsealed Block Rejected", func(t *testing.T) {
+		for crypto.ValidateHash(block.Hash, block.Header.Difficulty) {
+			block.Header.Nonce++
+			block.Hash = block.CalculateHash()
+		}
+		assert.ErrorIs(t, engine.VerifyHeader(chain, block, parent), consensus.ErrInvalidProofOfWork)
+	})
+
+	t.Run("Sealed Block Accepted", func(t *testing.T) {
+		require.NoError(t, engine.Seal(context.Background(), block))
+		assert.NoError(t, engine.VerifyHeader(chain, block, parent))
+	})
+
+	t.Run("Wrong Difficulty", func(t *testing.T) {
+		block.Header.Difficulty = testDifficulty + 1
+		require.NoError(t, engine.Seal(context.Background(), block))
+		assert.ErrorIs(t, engine.VerifyHeader(chain, block, parent), consensus.ErrInvalidDifficulty)
+	})
+}
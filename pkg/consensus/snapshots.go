@@ -0,0 +1,55 @@
+package consensus
+
+import (
+	"container/list"
+	"encoding/hex"
+)
+
+// maxSnapshots bounds the signer snapshots a PoAEngine keeps. Older ones are
+// rebuilt by replaying votes from genesis when needed again.
+const maxSnapshots = 256
+
+// snapshotCache keeps the most recently used snapshots by block hash. It is
+// not safe for concurrent use, PoAEngine guards it with its mutex.
+type snapshotCache struct {
+	max   int
+	order *list.List // Most recently used first
+	items map[string]*list.Element
+}
+
+type snapshotEntry struct {
+	key  string
+	snap *snapshot
+}
+
+func newSnapshotCache(max int) *snapshotCache {
+	return &snapshotCache{
+		max:   max,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *snapshotCache) get(hash []byte) (*snapshot, bool) {
+	elem, exists := c.items[hex.EncodeToString(hash)]
+	if !exists {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*snapshotEntry).snap, true
+}
+
+func (c *snapshotCache) add(hash []byte, snap *snapshot) {
+	key := hex.EncodeToString(hash)
+	if elem, exists := c.items[key]; exists {
+		elem.Value.(*snapshotEntry).snap = snap
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&snapshotEntry{key: key, snap: snap})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*snapshotEntry).key)
+	}
+}
@@ -0,0 +1,122 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+const (
+	MedianTimeBlocks   = 11
+	MaxFutureBlockTime = 2 * time.Minute
+)
+
+var (
+	ErrInvalidParent      = errors.New("invalid parent hash")
+	ErrInvalidIndex       = errors.New("invalid block index")
+	ErrInvalidDifficulty  = errors.New("invalid difficulty")
+	ErrInvalidProofOfWork = errors.New("hash does not meet difficulty")
+	ErrTimestampTooOld    = errors.New("timestamp not after median of recent blocks")
+	ErrTimestampTooFar    = errors.New("timestamp too far in the future")
+	ErrMissingCoinbase    = errors.New("first transaction is not a coinbase")
+	ErrMultipleCoinbase   = errors.New("more than one coinbase transaction")
+	ErrInvalidCoinbase    = errors.New("invalid coinbase transaction")
+	ErrInvalidStateRoot   = errors.New("state root mismatch")
+)
+
+// Validator checks blocks against the consensus rules that need chain
+// context, on top of the self-consistency checks done by Block.Validate.
+// Rules specific to the consensus algorithm are left to its Engine.
+type Validator struct {
+	chain  ChainReader
+	engine Engine
+
+	BlockReward uint64 // Amount every coinbase must pay
+}
+
+func NewValidator(chain ChainReader, engine Engine) *Validator {
+	return &Validator{chain: chain, engine: engine, BlockReward: types.CoinbaseAmount}
+}
+
+// ValidateBlock runs every check that can be done before executing the
+// block: header rules against parent and body layout.
+func (v *Validator) ValidateBlock(block, parent *types.Block) error {
+	if err := v.ValidateHeader(block, parent); err != nil {
+		return err
+	}
+	return v.ValidateBody(block)
+}
+
+func (v *Validator) ValidateHeader(block, parent *types.Block) error {
+	if !bytes.Equal(block.Header.ParentHash, parent.Hash) {
+		return fmt.Errorf("%w: %x, expected: %x", ErrInvalidParent, block.Header.ParentHash, parent.Hash)
+	}
+	if block.Header.Index != parent.Header.Index+1 {
+		return fmt.Errorf("%w: %d, expected: %d", ErrInvalidIndex, block.Header.Index, parent.Header.Index+1)
+	}
+
+	if err := v.engine.VerifyHeader(v.chain, block, parent); err != nil {
+		return err
+	}
+
+	median, err := MedianTimePast(v.chain, parent)
+	if err != nil {
+		return err
+	}
+	if block.Header.Timestamp <= median {
+		return fmt.Errorf("%w: %d, median: %d", ErrTimestampTooOld, block.Header.Timestamp, median)
+	}
+	if limit := time.Now().Add(MaxFutureBlockTime).Unix(); block.Header.Timestamp > limit {
+		return fmt.Errorf("%w: %d, limit: %d", ErrTimestampTooFar, block.Header.Timestamp, limit)
+	}
+
+	return block.Validate()
+}
+
+func (v *Validator) ValidateBody(block *types.Block) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return ErrMissingCoinbase
+	}
+	if coinbase := block.Transactions[0]; !coinbase.Verify() || coinbase.Ammount != v.BlockReward {
+		return ErrInvalidCoinbase
+	}
+	for i, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return fmt.Errorf("%w: transaction %d", ErrMultipleCoinbase, i+1)
+		}
+	}
+	return nil
+}
+
+// ValidateState compares the state root committed in block with the one
+// obtained by executing it.
+func (v *Validator) ValidateState(block *types.Block, stateRoot []byte) error {
+	if !bytes.Equal(block.StateRoot, stateRoot) {
+		return fmt.Errorf("%w: %x, computed: %x", ErrInvalidStateRoot, block.StateRoot, stateRoot)
+	}
+	return nil
+}
+
+// MedianTimePast returns the median timestamp of parent and the blocks
+// before it, up to MedianTimeBlocks of them. A new block must be newer.
+func MedianTimePast(chain ChainReader, parent *types.Block) (int64, error) {
+	timestamps := make([]int64, 0, MedianTimeBlocks)
+	block := parent
+	for {
+		timestamps = append(timestamps, block.Header.Timestamp)
+		if len(timestamps) == MedianTimeBlocks || block.Header.Index == 0 {
+			break
+		}
+		var err error
+		if block, err = chain.GetBlock(block.Header.ParentHash); err != nil {
+			return 0, fmt.Errorf("failed to load median time window: %w", err)
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
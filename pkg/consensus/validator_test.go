@@ -0,0 +1,150 @@
+package consensus_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDifficulty = 4
+
+// sealOn mines a block on top of parent after letting edit tweak it.
+func sealOn(parent *types.Block, edit func(b *types.Block)) *types.Block {
+	block := types.NewBlock(
+		parent.Header.Index+1,
+		[]*types.Transaction{types.NewCoinbaseTx("miner")},
+		parent.Hash,
+		"miner",
+	)
+	block.Header.Difficulty = testDifficulty
+	block.Header.Timestamp = parent.Header.Timestamp + 10
+	if edit != nil {
+		edit(block)
+	}
+	block.MerkleRoot = types.CalculateMerkleRoot(block.Transactions)
+	block.Header.Nonce, block.Hash = consensus.NewProofOfWork(block).Run()
+	return block
+}
+
+func TestValidator_ValidateHeader(t *testing.T) {
+	chain, parent := buildChain(5, testDifficulty, 10)
+	v := consensus.NewValidator(chain, consensus.NewPoWEngine(1))
+
+	t.Run("Valid Block", func(t *testing.T) {
+		assert.NoError(t, v.ValidateBlock(sealOn(parent, nil), parent))
+	})
+
+	t.Run("Wrong Parent", func(t *testing.T) {
+		block := sealOn(parent, func(b *types.Block) {
+			b.Header.ParentHash = crypto.HashData([]byte("other"))
+		})
+		assert.ErrorIs(t, v.ValidateHeader(block, parent), consensus.ErrInvalidParent)
+	})
+
+	t.Run("Wrong Index", func(t *testing.T) {
+		block := sealOn(parent, func(b *types.Block) { b.Header.Index += 1 })
+		assert.ErrorIs(t, v.ValidateHeader(block, parent), consensus.ErrInvalidIndex)
+	})
+
+	t.Run("Claimed Difficulty Too Low", func(t *testing.T) {
+		block := sealOn(parent, func(b *types.Block) { b.Header.Difficulty = 1 })
+		assert.ErrorIs(t, v.ValidateHeader(block, parent), consensus.ErrInvalidDifficulty)
+	})
+
+	t.Run("Timestamp At Median", func(t *testing.T) {
+		median, err := consensus.MedianTimePast(chain, parent)
+		require.NoError(t, err)
+		block := sealOn(parent, func(b *types.Block) { b.Header.Timestamp = median })
+		assert.ErrorIs(t, v.ValidateHeader(block, parent), consensus.ErrTimestampTooOld)
+	})
+
+	t.Run("Timestamp In The Future", func(t *testing.T) {
+		block := sealOn(parent, func(b *types.Block) {
+			b.Header.Timestamp = time.Now().Add(time.Hour).Unix()
+		})
+		assert.ErrorIs(t, v.ValidateHeader(block, parent), consensus.ErrTimestampTooFar)
+	})
+
+	t.Run("Tampered Hash", func(t *testing.T) {
+		block := sealOn(parent, nil)
+		block.Hash[0]++
+		assert.ErrorContains(t, v.ValidateHeader(block, parent), "invalid block hash")
+	})
+}
+
+func TestValidator_ValidateBody(t *testing.T) {
+	v := consensus.NewValidator(testChain{}, consensus.NewPoWEngine(1))
+
+	w := wallet.NewWallet()
+	tx := types.NewTransaction(0, w.Address, "to", 10, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	require.NoError(t, tx.Sign(w))
+
+	t.Run("Valid Body", func(t *testing.T) {
+		block := types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx("miner"), tx}, nil, "miner")
+		assert.NoError(t, v.ValidateBody(block))
+	})
+
+	t.Run("Empty Block", func(t *testing.T) {
+		block := types.NewBlock(1, nil, nil, "miner")
+		assert.ErrorIs(t, v.ValidateBody(block), consensus.ErrMissingCoinbase)
+	})
+
+	t.Run("Coinbase Not First", func(t *testing.T) {
+		block := types.NewBlock(1, []*types.Transaction{tx, types.NewCoinbaseTx("miner")}, nil, "miner")
+		assert.ErrorIs(t, v.ValidateBody(block), consensus.ErrMissingCoinbase)
+	})
+
+	t.Run("Two Coinbases", func(t *testing.T) {
+		block := types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx("miner"), types.NewCoinbaseTx("miner")}, nil, "miner")
+		assert.ErrorIs(t, v.ValidateBody(block), consensus.ErrMultipleCoinbase)
+	})
+
+	t.Run("Inflated Reward", func(t *testing.T) {
+		coinbase := types.NewCoinbaseTx("miner")
+		coinbase.Ammount = 1000
+		block := types.NewBlock(1, []*types.Transaction{coinbase}, nil, "miner")
+		assert.ErrorIs(t, v.ValidateBody(block), consensus.ErrInvalidCoinbase)
+	})
+
+	t.Run("Configured Reward", func(t *testing.T) {
+		custom := consensus.NewValidator(testChain{}, consensus.NewPoWEngine(1))
+		custom.BlockReward = 25
+
+		block := types.NewBlock(1, []*types.Transaction{types.NewRewardTx("miner", 25)}, nil, "miner")
+		assert.NoError(t, custom.ValidateBody(block))
+
+		block = types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx("miner")}, nil, "miner")
+		assert.ErrorIs(t, custom.ValidateBody(block), consensus.ErrInvalidCoinbase)
+	})
+}
+
+func TestValidator_ValidateState(t *testing.T) {
+	v := consensus.NewValidator(testChain{}, consensus.NewPoWEngine(1))
+	block := types.NewBlock(1, nil, nil, "miner")
+	block.StateRoot = crypto.HashData([]byte("state"))
+
+	assert.NoError(t, v.ValidateState(block, crypto.HashData([]byte("state"))))
+	assert.ErrorIs(t, v.ValidateState(block, crypto.HashData([]byte("other"))), consensus.ErrInvalidStateRoot)
+}
+
+func TestMedianTimePast(t *testing.T) {
+	t.Run("Full Window", func(t *testing.T) {
+		chain, tip := buildChain(20, testDifficulty, 10)
+		median, err := consensus.MedianTimePast(chain, tip)
+		require.NoError(t, err)
+		assert.Equal(t, tip.Header.Timestamp-50, median)
+	})
+
+	t.Run("Short Chain", func(t *testing.T) {
+		chain, tip := buildChain(2, testDifficulty, 10)
+		median, err := consensus.MedianTimePast(chain, tip)
+		require.NoError(t, err)
+		assert.Equal(t, tip.Header.Timestamp-10, median)
+	})
+}
@@ -35,7 +35,11 @@ func SignData(data []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return append(r.Bytes(), s.Bytes()...), nil
+	// Fixed width r||s so VerifySignature can split it in half
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature, nil
 }
 
 func VerifySignature(data []byte, signature []byte, publicKey *ecdsa.PublicKey) bool {
@@ -65,8 +69,11 @@ func NewPrivateKey(pubKeyBytes []byte, privKeyBytes []byte) *ecdsa.PrivateKey {
 	}
 }
 
+// AddressFromPublicKey hashes the fixed width encoding of PublicKeyToBytes.
+// Keys with a coordinate below 2^248 used to be hashed without its leading
+// zero bytes, so about one key in 128 has a different address than it did
+// before the encoding was fixed.
 func AddressFromPublicKey(pubKey *ecdsa.PublicKey) string {
-	pubBytes := append(pubKey.X.Bytes(), pubKey.Y.Bytes()...)
-	hash := HashData(pubBytes)
+	hash := HashData(PublicKeyToBytes(pubKey))
 	return hex.EncodeToString(hash[:20]) // First 20 bytes
 }
@@ -0,0 +1,61 @@
+package crypto_test
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// shortKey returns a key whose X coordinate has a leading zero byte, which
+// happens for about one key in 256.
+func shortKey(t *testing.T) *ecdsa.PrivateKey {
+	for {
+		key, err := crypto.GenerateKeyPair()
+		require.NoError(t, err)
+		if key.X.BitLen() <= 248 {
+			return key
+		}
+	}
+}
+
+func TestPublicKeyEncoding_ShortCoordinate(t *testing.T) {
+	key := shortKey(t)
+
+	t.Run("Padded To Fixed Width", func(t *testing.T) {
+		data := crypto.PublicKeyToBytes(&key.PublicKey)
+		require.Len(t, data, 64)
+		assert.Zero(t, data[0])
+
+		decoded, err := crypto.BytesToPublicKey(data)
+		require.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(decoded))
+	})
+
+	t.Run("Address Hashes Padded Key", func(t *testing.T) {
+		padded := crypto.PublicKeyToBytes(&key.PublicKey)
+		hash := crypto.HashData(padded)
+		assert.Equal(t, hex.EncodeToString(hash[:20]), crypto.AddressFromPublicKey(&key.PublicKey))
+
+		unpadded := append(key.X.Bytes(), key.Y.Bytes()...)
+		legacy := crypto.HashData(unpadded)
+		assert.NotEqual(t, hex.EncodeToString(legacy[:20]), crypto.AddressFromPublicKey(&key.PublicKey))
+	})
+}
+
+func TestSignData_FixedWidth(t *testing.T) {
+	key, err := crypto.GenerateKeyPair()
+	require.NoError(t, err)
+	data := crypto.HashData([]byte("payload"))
+
+	// About one signature in 128 has an r or s below 2^248
+	for range 2000 {
+		signature, err := crypto.SignData(data, key)
+		require.NoError(t, err)
+		require.Len(t, signature, 64)
+		require.True(t, crypto.VerifySignature(data, signature, &key.PublicKey))
+	}
+}
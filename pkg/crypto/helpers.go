@@ -59,8 +59,13 @@ func BuildMerkleRoot(hashes [][]byte) []byte {
 	return level[0]
 }
 
+// PublicKeyToBytes encodes X||Y, each left padded to 32 bytes, which is the
+// form BytesToPublicKey expects.
 func PublicKeyToBytes(pub *ecdsa.PublicKey) []byte {
-	return append(pub.X.Bytes(), pub.Y.Bytes()...)
+	data := make([]byte, 64)
+	pub.X.FillBytes(data[:32])
+	pub.Y.FillBytes(data[32:])
+	return data
 }
 
 func BytesToPublicKey(data []byte) (*ecdsa.PublicKey, error) {
@@ -0,0 +1,22 @@
+package mempool
+
+import "time"
+
+// Config bounds the resources the pool may use. Zero limits disable the
+// corresponding limit.
+type Config struct {
+	MaxTxs        int           // Transactions in the whole pool
+	MaxBytes      uint64        // Encoded size of the whole pool
+	MaxPerAccount int           // Transactions per sender, pending and queued
+	Lifetime      time.Duration // Age after which a transaction is dropped
+	ChainID       uint64        // Chain transactions must be signed for
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxTxs:        4096,
+		MaxBytes:      8 << 20,
+		MaxPerAccount: 64,
+		Lifetime:      3 * time.Hour,
+	}
+}
@@ -0,0 +1,30 @@
+package mempool
+
+import "github.com/karimseh/gochain/pkg/types"
+
+// SubscribeTxs returns a channel receiving each admitted transaction and a
+// function ending the subscription. A slow subscriber misses transactions
+// instead of holding up the pool.
+func (pool *TxPool) SubscribeTxs() (<-chan *types.Transaction, func()) {
+	ch := make(chan *types.Transaction, 16)
+
+	pool.mu.Lock()
+	pool.txSubs[ch] = struct{}{}
+	pool.mu.Unlock()
+
+	return ch, func() {
+		pool.mu.Lock()
+		delete(pool.txSubs, ch)
+		pool.mu.Unlock()
+	}
+}
+
+// notifyTx must be called with pool.mu held.
+func (pool *TxPool) notifyTx(tx *types.Transaction) {
+	for ch := range pool.txSubs {
+		select {
+		case ch <- tx:
+		default:
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package mempool
+
+import (
+	"container/heap"
+	"time"
+)
+
+// ageHeap orders pooled transactions oldest first, so expiring them only
+// looks at the ones due.
+type ageHeap []*pooledTx
+
+func (h ageHeap) Len() int { return len(h) }
+func (h ageHeap) Less(i, j int) bool {
+	if !h[i].added.Equal(h[j].added) {
+		return h[i].added.Before(h[j].added)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h ageHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].ageIndex = i
+	h[j].ageIndex = j
+}
+func (h *ageHeap) Push(x interface{}) {
+	entry := x.(*pooledTx)
+	entry.ageIndex = len(*h)
+	*h = append(*h, entry)
+}
+func (h *ageHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
+
+// expire drops transactions that stayed in the pool longer than Lifetime.
+func (pool *TxPool) expire() {
+	if pool.cfg.Lifetime <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-pool.cfg.Lifetime)
+	for len(pool.byAge) > 0 && pool.byAge[0].added.Before(cutoff) {
+		pool.drop(pool.byAge[0])
+		pool.stats.Expired++
+	}
+}
+
+func (pool *TxPool) trackAge(entry *pooledTx) {
+	heap.Push(&pool.byAge, entry)
+}
+
+func (pool *TxPool) untrackAge(entry *pooledTx) {
+	heap.Remove(&pool.byAge, entry.ageIndex)
+}
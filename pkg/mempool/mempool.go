@@ -2,77 +2,356 @@ package mempool
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/bits"
 	"sync"
+	"time"
 
 	"github.com/karimseh/gochain/pkg/types"
 )
 
+// PriceBump is the minimum fee increase, in percent, for a transaction to
+// replace a pooled one with the same sender and nonce.
+const PriceBump = 10
+
+var (
+	ErrAlreadyKnown         = errors.New("transaction already exists in pool")
+	ErrZeroAmount           = errors.New("transaction ammount must be greater than 0")
+	ErrWrongChain           = errors.New("transaction signed for another chain")
+	ErrInvalidSignature     = errors.New("transaction signature verification failed")
+	ErrCostOverflow         = errors.New("transaction cost overflows")
+	ErrNonceTooLow          = errors.New("nonce too low")
+	ErrInsufficientBalance  = errors.New("insufficient balance")
+	ErrReplacementUnderpaid = errors.New("replacement transaction underpriced")
+	ErrAccountFull          = errors.New("too many pooled transactions for account")
+	ErrPoolFull             = errors.New("transaction pool is full")
+)
+
+// AccountReader gives the pool the current account of a sender, against which
+// new transactions are checked.
+type AccountReader interface {
+	GetAccount(address string) (*types.Account, error)
+}
+
 type TxPool struct {
-	mu           sync.RWMutex
-	transactions map[string]*types.Transaction
-	orderedTxs   []*types.Transaction
+	mu       sync.RWMutex
+	cfg      Config
+	accounts AccountReader
+	all      map[string]*pooledTx
+	senders  map[string]*txList
+	byAge    ageHeap
+	bytes    uint64
+	seq      int
+	stats    Stats
+	txSubs   map[chan *types.Transaction]struct{}
 }
 
-func NewTxPool() *TxPool {
+func NewTxPool(accounts AccountReader) *TxPool {
+	return NewTxPoolWithConfig(accounts, DefaultConfig())
+}
+
+func NewTxPoolWithConfig(accounts AccountReader, cfg Config) *TxPool {
 	return &TxPool{
-		transactions: make(map[string]*types.Transaction),
-		orderedTxs:   make([]*types.Transaction, 0),
+		cfg:      cfg,
+		accounts: accounts,
+		all:      make(map[string]*pooledTx),
+		senders:  make(map[string]*txList),
+		stats:    Stats{Rejected: make(map[string]uint64), Invalidated: make(map[string]uint64)},
+		txSubs:   make(map[chan *types.Transaction]struct{}),
 	}
 }
 
+// AddTx admits tx if it can eventually be executed on top of the current
+// state. A transaction with the nonce of a pooled one replaces it when it
+// pays at least PriceBump percent more fee. When the pool is full the
+// cheapest transactions are evicted to make room, unless tx would be one of
+// them, in which case nothing is evicted.
 func (pool *TxPool) AddTx(tx *types.Transaction) error {
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
 
-	txhash := hex.EncodeToString(tx.Hash)
+	pool.expire()
+	if err := pool.add(tx); err != nil {
+		pool.stats.Rejected[rejectReason(err)]++
+		return err
+	}
+	return nil
+}
 
-	if _, exists := pool.transactions[txhash]; exists {
-		return fmt.Errorf("transaction already exists in pool")
+func (pool *TxPool) add(tx *types.Transaction) error {
+	if _, exists := pool.all[hex.EncodeToString(tx.Hash)]; exists {
+		return ErrAlreadyKnown
 	}
 
 	if tx.Ammount == 0 {
-		return fmt.Errorf("transaction ammount must be greater than 0")
+		return ErrZeroAmount
 	}
 
+	if tx.ChainID != pool.cfg.ChainID {
+		return fmt.Errorf("%w: %d, expected: %d", ErrWrongChain, tx.ChainID, pool.cfg.ChainID)
+	}
 	if !tx.Verify() {
-		return fmt.Errorf("transaction signature verification failed")
+		return ErrInvalidSignature
+	}
+
+	acc, err := pool.accounts.GetAccount(tx.From)
+	if err != nil {
+		return err
+	}
+	if tx.Nonce <= acc.Nonce {
+		return fmt.Errorf("%w: %d, account nonce: %d", ErrNonceTooLow, tx.Nonce, acc.Nonce)
+	}
+	cost, ok := tx.Cost()
+	if !ok {
+		return ErrCostOverflow
+	}
+
+	list := pool.senders[tx.From]
+	if list == nil {
+		list = newTxList()
+	}
+	old := list.get(tx.Nonce)
+	if old != nil && !replaces(tx, old.tx) {
+		return fmt.Errorf("%w: fee %d, pooled fee: %d", ErrReplacementUnderpaid, tx.Fee, old.tx.Fee)
+	}
+	if old == nil && pool.cfg.MaxPerAccount > 0 && list.len() >= pool.cfg.MaxPerAccount {
+		return fmt.Errorf("%w: %d", ErrAccountFull, list.len())
+	}
+	// The sender must afford this transaction after every earlier one
+	below, ok := list.costBelow(tx.Nonce)
+	required, carry := bits.Add64(below, cost, 0)
+	if !ok || carry != 0 {
+		return fmt.Errorf("%w: with the earlier transactions of %s", ErrCostOverflow, tx.From)
+	}
+	if required > acc.Balance {
+		return fmt.Errorf("%w: %d, required: %d", ErrInsufficientBalance, acc.Balance, required)
+	}
+
+	if old != nil {
+		pool.drop(old)
+	}
+	entry := &pooledTx{tx: tx, seq: pool.seq, size: uint64(tx.Size()), added: time.Now()}
+	pool.seq++
+	pool.insert(entry)
+	pool.reset(tx.From, acc)
+
+	victims, admitted := pool.victims(entry)
+	if !admitted {
+		pool.drop(entry)
+		if old != nil {
+			pool.insert(old)
+		}
+		pool.reset(tx.From, acc)
+		return ErrPoolFull
+	}
+	for _, victim := range victims {
+		pool.drop(victim)
+		pool.stats.Evicted++
 	}
 
-	pool.transactions[txhash] = tx
-	pool.orderedTxs = append(pool.orderedTxs, tx)
+	if old != nil {
+		pool.stats.Replaced++
+	}
+	pool.stats.Added++
+	pool.notifyTx(tx)
 	return nil
 }
 
+// replaces reports whether tx pays enough to replace old.
+func replaces(tx, old *types.Transaction) bool {
+	return tx.Fee > old.Fee && mulCmp(tx.Fee, 100, old.Fee, 100+PriceBump) >= 0
+}
+
+// insert adds entry to the queue of its sender; reset decides if it is
+// pending.
+func (pool *TxPool) insert(entry *pooledTx) {
+	list := pool.senders[entry.tx.From]
+	if list == nil {
+		list = newTxList()
+		pool.senders[entry.tx.From] = list
+	}
+	list.queued[entry.tx.Nonce] = entry
+	pool.all[hex.EncodeToString(entry.tx.Hash)] = entry
+	pool.bytes += entry.size
+	pool.trackAge(entry)
+}
+
+func (pool *TxPool) drop(entry *pooledTx) {
+	delete(pool.all, hex.EncodeToString(entry.tx.Hash))
+	pool.bytes -= entry.size
+	pool.untrackAge(entry)
+	if list := pool.senders[entry.tx.From]; list != nil {
+		list.remove(entry.tx.Nonce)
+		if list.empty() {
+			delete(pool.senders, entry.tx.From)
+		}
+	}
+}
+
+// victims picks the cheapest transactions to evict for the pool to fit its
+// limits, without evicting them yet. Only the last transaction of a sender
+// is picked so no gap is left behind. It reports false if entry itself would
+// have to go.
+func (pool *TxPool) victims(entry *pooledTx) ([]*pooledTx, bool) {
+	count, size := len(pool.all), pool.bytes
+	picked := make(map[string][]*pooledTx) // Remaining transactions by nonce, once one is picked
+	var victims []*pooledTx
+	for pool.exceeds(count, size) {
+		var victim *pooledTx
+		for from, list := range pool.senders {
+			tail := list.tail()
+			if remaining, exists := picked[from]; exists {
+				if len(remaining) == 0 {
+					continue
+				}
+				tail = remaining[len(remaining)-1]
+			}
+			if victim == nil || higherFeeRate(victim, tail) {
+				victim = tail
+			}
+		}
+		if victim == nil || victim == entry {
+			return nil, false
+		}
+		from := victim.tx.From
+		remaining, exists := picked[from]
+		if !exists {
+			remaining = pool.senders[from].byNonce()
+		}
+		picked[from] = remaining[:len(remaining)-1]
+		victims = append(victims, victim)
+		count--
+		size -= victim.size
+	}
+	return victims, true
+}
+
+func (pool *TxPool) exceeds(count int, size uint64) bool {
+	return (pool.cfg.MaxTxs > 0 && count > pool.cfg.MaxTxs) ||
+		(pool.cfg.MaxBytes > 0 && size > pool.cfg.MaxBytes)
+}
+
+// GetTxs returns up to max pending transactions, best paying per byte first.
+// A sender's transactions always come in nonce order, so a high fee cannot
+// jump ahead of the sender's own lower nonces.
 func (pool *TxPool) GetTxs(max int) []*types.Transaction {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
-	if max <= 0 || max > len(pool.orderedTxs) {
-		max = len(pool.orderedTxs)
+
+	var pending []*pooledTx
+	for _, list := range pool.senders {
+		for _, entry := range list.pending {
+			pending = append(pending, entry)
+		}
+	}
+	if max <= 0 || max > len(pending) {
+		max = len(pending)
 	}
-	return pool.orderedTxs[:max]
+	return byPriority(pending, max)
 }
 
+// RemoveTxs drops txs, typically because they were included in a block, and
+// re-sorts their senders against the updated state.
 func (pool *TxPool) RemoveTxs(txs []*types.Transaction) {
 	pool.mu.Lock()
 	defer pool.mu.Unlock()
 
+	senders := make(map[string]bool)
 	for _, tx := range txs {
-		txHash := hex.EncodeToString(tx.Hash)
-		delete(pool.transactions, txHash)
+		senders[tx.From] = true
+		if entry, exists := pool.all[hex.EncodeToString(tx.Hash)]; exists {
+			pool.drop(entry)
+		}
 	}
 
-	newOrdered := make([]*types.Transaction, 0, len(pool.transactions))
-	for _, tx := range pool.orderedTxs {
-		if _, exists := pool.transactions[hex.EncodeToString(tx.Hash)]; exists {
-			newOrdered = append(newOrdered, tx)
+	for from := range senders {
+		if pool.senders[from] == nil {
+			continue
+		}
+		acc, err := pool.accounts.GetAccount(from)
+		if err != nil {
+			continue
+		}
+		pool.reset(from, acc)
+	}
+	pool.expire()
+}
+
+// Invalidated is a transaction Revalidate dropped or demoted, and why.
+type Invalidated struct {
+	Tx      *types.Transaction
+	Reason  error
+	Demoted bool // Moved back to the queue rather than dropped
+}
+
+// Revalidate checks every sender against the current state, after a new block
+// or a reorganization. Transactions whose nonce has been used are dropped and
+// pending ones the sender can no longer afford are queued again.
+func (pool *TxPool) Revalidate() []Invalidated {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var invalid []Invalidated
+	for from := range pool.senders {
+		acc, err := pool.accounts.GetAccount(from)
+		if err != nil {
+			continue
 		}
+		stale, demoted := pool.reset(from, acc)
+		for _, entry := range stale {
+			invalid = append(invalid, Invalidated{
+				Tx:     entry.tx,
+				Reason: fmt.Errorf("%w: %d, account nonce: %d", ErrNonceTooLow, entry.tx.Nonce, acc.Nonce),
+			})
+		}
+		for _, entry := range demoted {
+			invalid = append(invalid, Invalidated{
+				Tx:      entry.tx,
+				Reason:  fmt.Errorf("%w: %d", ErrInsufficientBalance, acc.Balance),
+				Demoted: true,
+			})
+		}
+	}
+	for _, inv := range invalid {
+		pool.stats.Invalidated[rejectReason(inv.Reason)]++
+	}
+	return invalid
+}
+
+// reset promotes or demotes the transactions of from against acc and drops
+// the ones it makes stale.
+func (pool *TxPool) reset(from string, acc *types.Account) (stale, demoted []*pooledTx) {
+	list := pool.senders[from]
+	if list == nil {
+		return nil, nil
+	}
+	stale, demoted = list.reset(acc)
+	for _, entry := range stale {
+		pool.drop(entry)
 	}
-	pool.orderedTxs = newOrdered
+	return stale, demoted
 }
 
+// PendingCount returns the number of transactions ready to be mined.
 func (pool *TxPool) PendingCount() int {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
-	return len(pool.transactions)
+	count := 0
+	for _, list := range pool.senders {
+		count += len(list.pending)
+	}
+	return count
+}
+
+// QueuedCount returns the number of transactions waiting for a nonce gap to
+// be filled.
+func (pool *TxPool) QueuedCount() int {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	count := 0
+	for _, list := range pool.senders {
+		count += len(list.queued)
+	}
+	return count
 }
@@ -1,6 +1,8 @@
 package mempool_test
 
 import (
+	"math"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -13,15 +15,26 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// accounts is an AccountReader where unknown senders are well funded.
+type accounts map[string]*types.Account
+
+func (a accounts) GetAccount(address string) (*types.Account, error) {
+	if acc, exists := a[address]; exists {
+		copied := *acc
+		return &copied, nil
+	}
+	return &types.Account{Address: address, Balance: 1_000_000}, nil
+}
+
 func newValidTx() *types.Transaction {
 	w := wallet.NewWallet()
-	tx := types.NewTransaction(w.Address, "recipient", 100, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	tx := types.NewTransaction(0, w.Address, "recipient", 100, 1, crypto.PublicKeyToBytes(w.PublicKey))
 	_ = tx.Sign(w)
 	return tx
 }
 
 func TestTxPool_AddTx(t *testing.T) {
-	pool := mempool.NewTxPool()
+	pool := mempool.NewTxPool(accounts{})
 
 	t.Run("Valid Transaction", func(t *testing.T) {
 		tx := newValidTx()
@@ -50,10 +63,19 @@ func TestTxPool_AddTx(t *testing.T) {
 		err := pool.AddTx(tx)
 		assert.ErrorContains(t, err, "signature verification")
 	})
+
+	t.Run("Wrong Chain", func(t *testing.T) {
+		cfg := mempool.DefaultConfig()
+		cfg.ChainID = 7
+		other := mempool.NewTxPoolWithConfig(accounts{}, cfg)
+
+		assert.ErrorIs(t, other.AddTx(newValidTx()), mempool.ErrWrongChain)
+		assert.Equal(t, uint64(1), other.Stats().Rejected["wrong_chain"])
+	})
 }
 
 func TestTxPool_GetTxs(t *testing.T) {
-	pool := mempool.NewTxPool()
+	pool := mempool.NewTxPool(accounts{})
 
 	txs := []*types.Transaction{
 		newValidTx(),
@@ -84,7 +106,7 @@ func TestTxPool_GetTxs(t *testing.T) {
 }
 
 func TestTxPool_RemoveTxs(t *testing.T) {
-	pool := mempool.NewTxPool()
+	pool := mempool.NewTxPool(accounts{})
 
 	txs := []*types.Transaction{
 		newValidTx(),
@@ -107,7 +129,7 @@ func TestTxPool_RemoveTxs(t *testing.T) {
 }
 
 func TestTxPool_Concurrency(t *testing.T) {
-	pool := mempool.NewTxPool()
+	pool := mempool.NewTxPool(accounts{})
 	var wg sync.WaitGroup
 
 	for i := 0; i < 10; i++ {
@@ -136,7 +158,7 @@ func TestTxPool_Concurrency(t *testing.T) {
 }
 
 func TestTxPool_Ordering(t *testing.T) {
-	pool := mempool.NewTxPool()
+	pool := mempool.NewTxPool(accounts{})
 
 	txs := make([]*types.Transaction, 5)
 	for i := range txs {
@@ -153,15 +175,271 @@ func TestTxPool_Ordering(t *testing.T) {
 	assert.Equal(t, []*types.Transaction{txs[0], txs[1], txs[3], txs[4]}, result)
 }
 
+func newTxFrom(w *wallet.Wallet, nonce, fee uint64) *types.Transaction {
+	tx := types.NewTransaction(0, w.Address, "recipient", 100, nonce, crypto.PublicKeyToBytes(w.PublicKey))
+	tx.Fee = fee
+	_ = tx.Sign(w)
+	return tx
+}
+
+func TestTxPool_FeePriority(t *testing.T) {
+	state := accounts{}
+	pool := mempool.NewTxPool(state)
+	alice, bob := wallet.NewWallet(), wallet.NewWallet()
+
+	low := newTxFrom(alice, 1, 1)
+	high := newTxFrom(alice, 2, 100) // Must wait for alice's nonce 1
+	mid := newTxFrom(bob, 1, 10)
+	tie := newTxFrom(wallet.NewWallet(), 1, 10)
+	for _, tx := range []*types.Transaction{high, low, mid, tie} {
+		require.NoError(t, pool.AddTx(tx))
+	}
+
+	t.Run("Highest Fee Rate First", func(t *testing.T) {
+		assert.Equal(t, []*types.Transaction{mid, tie, low, high}, pool.GetTxs(0))
+	})
+
+	t.Run("Limit Keeps Best", func(t *testing.T) {
+		assert.Equal(t, []*types.Transaction{mid, tie}, pool.GetTxs(2))
+	})
+
+	t.Run("Sender Unblocked", func(t *testing.T) {
+		state[alice.Address] = &types.Account{Address: alice.Address, Balance: 1_000_000, Nonce: 1}
+		pool.RemoveTxs([]*types.Transaction{low})
+		assert.Equal(t, high, pool.GetTxs(1)[0])
+	})
+}
+
+func TestTxPool_Nonces(t *testing.T) {
+	w := wallet.NewWallet()
+	state := accounts{w.Address: {Address: w.Address, Balance: 350, Nonce: 1}}
+	pool := mempool.NewTxPool(state)
+
+	t.Run("Nonce Too Low", func(t *testing.T) {
+		assert.ErrorIs(t, pool.AddTx(newTxFrom(w, 1, 0)), mempool.ErrNonceTooLow)
+	})
+
+	t.Run("Gap Is Queued", func(t *testing.T) {
+		require.NoError(t, pool.AddTx(newTxFrom(w, 3, 0)))
+		assert.Zero(t, pool.PendingCount())
+		assert.Equal(t, 1, pool.QueuedCount())
+		assert.Empty(t, pool.GetTxs(0))
+	})
+
+	t.Run("Filling Gap Promotes", func(t *testing.T) {
+		require.NoError(t, pool.AddTx(newTxFrom(w, 2, 0)))
+		assert.Equal(t, 2, pool.PendingCount())
+		assert.Zero(t, pool.QueuedCount())
+	})
+
+	t.Run("Cumulative Overspend", func(t *testing.T) {
+		// 100 + 100 already pooled, 350 available
+		assert.ErrorIs(t, pool.AddTx(newTxFrom(w, 4, 51)), mempool.ErrInsufficientBalance)
+		require.NoError(t, pool.AddTx(newTxFrom(w, 4, 50)))
+	})
+
+	t.Run("Replace By Fee", func(t *testing.T) {
+		assert.ErrorIs(t, pool.AddTx(newTxFrom(w, 4, 54)), mempool.ErrReplacementUnderpaid)
+
+		state[w.Address].Balance = 1000
+		replacement := newTxFrom(w, 4, 55)
+		require.NoError(t, pool.AddTx(replacement))
+		assert.Equal(t, 3, pool.PendingCount())
+		assert.Contains(t, pool.GetTxs(0), replacement)
+	})
+
+	t.Run("Mined Nonces Dropped", func(t *testing.T) {
+		state[w.Address].Nonce = 3
+		pool.RemoveTxs([]*types.Transaction{newTxFrom(w, 3, 7)}) // Mined from elsewhere
+		assert.Equal(t, 1, pool.PendingCount())
+		assert.Equal(t, uint64(4), pool.GetTxs(0)[0].Nonce)
+	})
+}
+
+func TestTxPool_Limits(t *testing.T) {
+	t.Run("Per Account Slots", func(t *testing.T) {
+		pool := mempool.NewTxPoolWithConfig(accounts{}, mempool.Config{MaxPerAccount: 2})
+		w := wallet.NewWallet()
+		require.NoError(t, pool.AddTx(newTxFrom(w, 1, 0)))
+		require.NoError(t, pool.AddTx(newTxFrom(w, 2, 0)))
+		assert.ErrorIs(t, pool.AddTx(newTxFrom(w, 3, 0)), mempool.ErrAccountFull)
+		require.NoError(t, pool.AddTx(newTxFrom(w, 2, 10)), "Replacement needs no extra slot")
+	})
+
+	t.Run("Evicts Cheapest", func(t *testing.T) {
+		pool := mempool.NewTxPoolWithConfig(accounts{}, mempool.Config{MaxTxs: 2})
+		cheap := newTxFrom(wallet.NewWallet(), 1, 1)
+		mid := newTxFrom(wallet.NewWallet(), 1, 5)
+		require.NoError(t, pool.AddTx(cheap))
+		require.NoError(t, pool.AddTx(mid))
+
+		rich := newTxFrom(wallet.NewWallet(), 1, 10)
+		require.NoError(t, pool.AddTx(rich))
+		assert.Equal(t, []*types.Transaction{rich, mid}, pool.GetTxs(0))
+
+		assert.ErrorIs(t, pool.AddTx(newTxFrom(wallet.NewWallet(), 1, 2)), mempool.ErrPoolFull)
+		assert.Equal(t, []*types.Transaction{rich, mid}, pool.GetTxs(0))
+	})
+
+	t.Run("Evicts Sender Tail", func(t *testing.T) {
+		pool := mempool.NewTxPoolWithConfig(accounts{}, mempool.Config{MaxTxs: 2})
+		w := wallet.NewWallet()
+		first := newTxFrom(w, 1, 0)
+		second := newTxFrom(w, 2, 50)
+		require.NoError(t, pool.AddTx(first))
+		require.NoError(t, pool.AddTx(second))
+
+		// first is cheaper, but evicting it would strand second
+		other := newTxFrom(wallet.NewWallet(), 1, 60)
+		require.NoError(t, pool.AddTx(other))
+		assert.ElementsMatch(t, []*types.Transaction{first, other}, pool.GetTxs(0))
+	})
+
+	t.Run("Rejection Evicts Nothing", func(t *testing.T) {
+		small := []*types.Transaction{
+			newTxFrom(wallet.NewWallet(), 1, 1000),
+			newTxFrom(wallet.NewWallet(), 1, 10),
+			newTxFrom(wallet.NewWallet(), 1, 1000),
+		}
+		var size uint64
+		for _, tx := range small {
+			size += uint64(tx.Size())
+		}
+		pool := mempool.NewTxPoolWithConfig(accounts{}, mempool.Config{MaxBytes: size})
+		for _, tx := range small {
+			require.NoError(t, pool.AddTx(tx))
+		}
+
+		// Evicting the cheap one is not enough room, and the big one pays
+		// less per byte than the others
+		w := wallet.NewWallet()
+		big := types.NewTransaction(0, w.Address, strings.Repeat("r", 2*small[0].Size()), 100, 1, crypto.PublicKeyToBytes(w.PublicKey))
+		big.Fee = 100
+		require.NoError(t, big.Sign(w))
+
+		assert.ErrorIs(t, pool.AddTx(big), mempool.ErrPoolFull)
+		assert.ElementsMatch(t, small, pool.GetTxs(0))
+		assert.Zero(t, pool.Stats().Evicted)
+	})
+
+	t.Run("Byte Limit", func(t *testing.T) {
+		tx := newValidTx()
+		pool := mempool.NewTxPoolWithConfig(accounts{}, mempool.Config{MaxBytes: uint64(tx.Size())})
+		require.NoError(t, pool.AddTx(tx))
+		assert.ErrorIs(t, pool.AddTx(newValidTx()), mempool.ErrPoolFull)
+		assert.Equal(t, uint64(tx.Size()), pool.Stats().Bytes)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		pool := mempool.NewTxPoolWithConfig(accounts{}, mempool.Config{Lifetime: 50 * time.Millisecond})
+		old := newValidTx()
+		require.NoError(t, pool.AddTx(old))
+
+		time.Sleep(60 * time.Millisecond)
+		fresh := newValidTx()
+		require.NoError(t, pool.AddTx(fresh))
+		assert.Equal(t, []*types.Transaction{fresh}, pool.GetTxs(0))
+		assert.Equal(t, uint64(1), pool.Stats().Expired)
+	})
+}
+
+func TestTxPool_CostOverflow(t *testing.T) {
+	w := wallet.NewWallet()
+	pool := mempool.NewTxPool(accounts{w.Address: {Address: w.Address, Balance: math.MaxUint64}})
+
+	require.NoError(t, pool.AddTx(newTxFrom(w, 1, math.MaxUint64-200)))
+	assert.ErrorIs(t, pool.AddTx(newTxFrom(w, 2, 100)), mempool.ErrCostOverflow, "Costs of a sender must not wrap around")
+	assert.Equal(t, 1, pool.PendingCount()+pool.QueuedCount())
+}
+
+func TestTxPool_Stats(t *testing.T) {
+	pool := mempool.NewTxPoolWithConfig(accounts{}, mempool.Config{MaxTxs: 1})
+	w := wallet.NewWallet()
+
+	require.NoError(t, pool.AddTx(newTxFrom(w, 1, 1)))
+	require.NoError(t, pool.AddTx(newTxFrom(w, 1, 2)))
+	_ = pool.AddTx(newTxFrom(w, 1, 2))
+	_ = pool.AddTx(newTxFrom(w, 0, 5))
+	_ = pool.AddTx(newTxFrom(wallet.NewWallet(), 1, 0))
+	require.NoError(t, pool.AddTx(newTxFrom(wallet.NewWallet(), 1, 9)))
+
+	stats := pool.Stats()
+	assert.Equal(t, 1, stats.Pending)
+	assert.Equal(t, uint64(3), stats.Added)
+	assert.Equal(t, uint64(1), stats.Replaced)
+	assert.Equal(t, uint64(1), stats.Evicted)
+	assert.Equal(t, map[string]uint64{"known": 1, "nonce_too_low": 1, "pool_full": 1}, stats.Rejected)
+}
+
+func TestTxPool_Revalidate(t *testing.T) {
+	w := wallet.NewWallet()
+	state := accounts{w.Address: {Address: w.Address, Balance: 1000}}
+	pool := mempool.NewTxPool(state)
+
+	first, second, third := newTxFrom(w, 1, 0), newTxFrom(w, 2, 0), newTxFrom(w, 3, 0)
+	for _, tx := range []*types.Transaction{first, second, third} {
+		require.NoError(t, pool.AddTx(tx))
+	}
+	other := newValidTx()
+	require.NoError(t, pool.AddTx(other))
+
+	t.Run("Nothing Changed", func(t *testing.T) {
+		assert.Empty(t, pool.Revalidate())
+	})
+
+	t.Run("Nonce Consumed Elsewhere", func(t *testing.T) {
+		// A competing transaction with nonce 1 was mined and drained the account
+		state[w.Address] = &types.Account{Address: w.Address, Balance: 150, Nonce: 1}
+
+		invalid := pool.Revalidate()
+		require.Len(t, invalid, 2)
+		byTx := map[*types.Transaction]mempool.Invalidated{}
+		for _, inv := range invalid {
+			byTx[inv.Tx] = inv
+		}
+
+		assert.ErrorIs(t, byTx[first].Reason, mempool.ErrNonceTooLow)
+		assert.False(t, byTx[first].Demoted)
+		assert.ErrorIs(t, byTx[third].Reason, mempool.ErrInsufficientBalance)
+		assert.True(t, byTx[third].Demoted)
+
+		assert.ElementsMatch(t, []*types.Transaction{second, other}, pool.GetTxs(0))
+		assert.Equal(t, 1, pool.QueuedCount())
+	})
+
+	t.Run("Funds Arrive", func(t *testing.T) {
+		state[w.Address].Balance = 1000
+		assert.Empty(t, pool.Revalidate())
+		assert.Equal(t, 3, pool.PendingCount())
+
+		stats := pool.Stats()
+		assert.Equal(t, map[string]uint64{"nonce_too_low": 1, "insufficient_balance": 1}, stats.Invalidated)
+	})
+}
+
+func TestTxPool_SubscribeTxs(t *testing.T) {
+	pool := mempool.NewTxPool(accounts{})
+	txs, unsubscribe := pool.SubscribeTxs()
+
+	tx := newValidTx()
+	require.NoError(t, pool.AddTx(tx))
+	assert.Equal(t, tx, <-txs)
+
+	_ = pool.AddTx(tx) // Rejected, not announced
+	unsubscribe()
+	require.NoError(t, pool.AddTx(newValidTx()))
+	assert.Empty(t, txs)
+}
+
 func TestTxPool_EdgeCases(t *testing.T) {
 	t.Run("Empty Pool", func(t *testing.T) {
-		pool := mempool.NewTxPool()
+		pool := mempool.NewTxPool(accounts{})
 		assert.Zero(t, pool.PendingCount())
 		assert.Empty(t, pool.GetTxs(0))
 	})
 
 	t.Run("Remove Non-Existent", func(t *testing.T) {
-		pool := mempool.NewTxPool()
+		pool := mempool.NewTxPool(accounts{})
 		pool.RemoveTxs([]*types.Transaction{newValidTx()})
 		assert.Zero(t, pool.PendingCount())
 	})
@@ -0,0 +1,93 @@
+package mempool
+
+import (
+	"container/heap"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// pooledTx is a transaction with its position in arrival order.
+type pooledTx struct {
+	tx       *types.Transaction
+	seq      int
+	size     uint64
+	added    time.Time
+	ageIndex int // Position in TxPool.byAge
+}
+
+// higherFeeRate reports whether a pays more per byte than b. Ties go to the
+// transaction that arrived first.
+func higherFeeRate(a, b *pooledTx) bool {
+	// Compare a.Fee/a.size with b.Fee/b.size without dividing
+	if c := mulCmp(a.tx.Fee, b.size, b.tx.Fee, a.size); c != 0 {
+		return c > 0
+	}
+	return a.seq < b.seq
+}
+
+// mulCmp compares a*x with b*y without overflowing.
+func mulCmp(a, x, b, y uint64) int {
+	aHi, aLo := bits.Mul64(a, x)
+	bHi, bLo := bits.Mul64(b, y)
+	if aHi != bHi {
+		return cmpUint64(aHi, bHi)
+	}
+	return cmpUint64(aLo, bLo)
+}
+
+func cmpUint64(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// senderHeap holds the next executable transaction of each sender.
+type senderHeap [][]*pooledTx
+
+func (h senderHeap) Len() int            { return len(h) }
+func (h senderHeap) Less(i, j int) bool  { return higherFeeRate(h[i][0], h[j][0]) }
+func (h senderHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *senderHeap) Push(x interface{}) { *h = append(*h, x.([]*pooledTx)) }
+func (h *senderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// byPriority orders pending transactions by fee per byte, highest first,
+// while keeping the transactions of each sender in nonce order.
+func byPriority(entries []*pooledTx, max int) []*types.Transaction {
+	senders := make(map[string][]*pooledTx)
+	for _, entry := range entries {
+		senders[entry.tx.From] = append(senders[entry.tx.From], entry)
+	}
+
+	h := make(senderHeap, 0, len(senders))
+	for _, queue := range senders {
+		sort.Slice(queue, func(i, j int) bool { return queue[i].tx.Nonce < queue[j].tx.Nonce })
+		h = append(h, queue)
+	}
+	heap.Init(&h)
+
+	result := make([]*types.Transaction, 0, max)
+	for h.Len() > 0 && len(result) < max {
+		queue := h[0]
+		result = append(result, queue[0].tx)
+		if len(queue) == 1 {
+			heap.Pop(&h)
+			continue
+		}
+		h[0] = queue[1:]
+		heap.Fix(&h, 0)
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package mempool
+
+import "errors"
+
+// Stats is a snapshot of the pool's size and of what happened to the
+// transactions submitted to it.
+type Stats struct {
+	Pending  int
+	Queued   int
+	Bytes    uint64
+	Added    uint64
+	Replaced uint64
+	Evicted  uint64
+	Expired  uint64
+	Rejected map[string]uint64 // By reason
+
+	Invalidated map[string]uint64 // Dropped or demoted by Revalidate, by reason
+}
+
+var rejectReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrAlreadyKnown, "known"},
+	{ErrZeroAmount, "zero_amount"},
+	{ErrWrongChain, "wrong_chain"},
+	{ErrInvalidSignature, "invalid_signature"},
+	{ErrCostOverflow, "cost_overflow"},
+	{ErrNonceTooLow, "nonce_too_low"},
+	{ErrInsufficientBalance, "insufficient_balance"},
+	{ErrReplacementUnderpaid, "underpriced"},
+	{ErrAccountFull, "account_full"},
+	{ErrPoolFull, "pool_full"},
+}
+
+func rejectReason(err error) string {
+	for _, r := range rejectReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+func (pool *TxPool) Stats() Stats {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	stats := pool.stats
+	stats.Rejected = copyCounts(pool.stats.Rejected)
+	stats.Invalidated = copyCounts(pool.stats.Invalidated)
+	for _, list := range pool.senders {
+		stats.Pending += len(list.pending)
+		stats.Queued += len(list.queued)
+	}
+	stats.Bytes = pool.bytes
+	return stats
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	copied := make(map[string]uint64, len(counts))
+	for reason, count := range counts {
+		copied[reason] = count
+	}
+	return copied
+}
@@ -0,0 +1,142 @@
+package mempool
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// txList holds the transactions of one sender by nonce. Pending ones follow
+// the account nonce without gaps and can be mined right away; queued ones
+// wait for a gap before them to be filled.
+type txList struct {
+	pending map[uint64]*pooledTx
+	queued  map[uint64]*pooledTx
+}
+
+func newTxList() *txList {
+	return &txList{
+		pending: make(map[uint64]*pooledTx),
+		queued:  make(map[uint64]*pooledTx),
+	}
+}
+
+func (l *txList) get(nonce uint64) *pooledTx {
+	if entry, exists := l.pending[nonce]; exists {
+		return entry
+	}
+	return l.queued[nonce]
+}
+
+// remove deletes the transaction at nonce. Pending transactions after it can
+// no longer run and go back to the queue.
+func (l *txList) remove(nonce uint64) {
+	if _, exists := l.pending[nonce]; !exists {
+		delete(l.queued, nonce)
+		return
+	}
+	delete(l.pending, nonce)
+	for n, entry := range l.pending {
+		if n > nonce {
+			delete(l.pending, n)
+			l.queued[n] = entry
+		}
+	}
+}
+
+func (l *txList) len() int {
+	return len(l.pending) + len(l.queued)
+}
+
+func (l *txList) empty() bool {
+	return l.len() == 0
+}
+
+// tail returns the transaction with the highest nonce.
+func (l *txList) tail() *pooledTx {
+	var tail *pooledTx
+	for _, entries := range []map[uint64]*pooledTx{l.pending, l.queued} {
+		for _, entry := range entries {
+			if tail == nil || entry.tx.Nonce > tail.tx.Nonce {
+				tail = entry
+			}
+		}
+	}
+	return tail
+}
+
+// byNonce returns every transaction of the list, lowest nonce first.
+func (l *txList) byNonce() []*pooledTx {
+	entries := make([]*pooledTx, 0, l.len())
+	for _, group := range []map[uint64]*pooledTx{l.pending, l.queued} {
+		for _, entry := range group {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tx.Nonce < entries[j].tx.Nonce })
+	return entries
+}
+
+// costBelow sums what the transactions before nonce will spend. It reports
+// false if the sum overflows.
+func (l *txList) costBelow(nonce uint64) (uint64, bool) {
+	var total uint64
+	for _, entries := range []map[uint64]*pooledTx{l.pending, l.queued} {
+		for n, entry := range entries {
+			if n >= nonce {
+				continue
+			}
+			cost, ok := entry.tx.Cost()
+			if !ok {
+				return 0, false
+			}
+			var carry uint64
+			if total, carry = bits.Add64(total, cost, 0); carry != 0 {
+				return 0, false
+			}
+		}
+	}
+	return total, true
+}
+
+// reset rebuilds pending as the run of nonces right after the account nonce
+// that the account balance can pay for, and queues the rest. Transactions at
+// or below the account nonce can no longer be executed and are returned as
+// stale; pending ones moved back to the queue are returned as demoted.
+func (l *txList) reset(acc *types.Account) (stale, demoted []*pooledTx) {
+	queued := make(map[uint64]*pooledTx, len(l.pending)+len(l.queued))
+	for _, entries := range []map[uint64]*pooledTx{l.pending, l.queued} {
+		for nonce, entry := range entries {
+			if nonce <= acc.Nonce {
+				stale = append(stale, entry)
+				continue
+			}
+			queued[nonce] = entry
+		}
+	}
+
+	pending := make(map[uint64]*pooledTx)
+	var spent uint64
+	for next := acc.Nonce + 1; ; next++ {
+		entry, exists := queued[next]
+		if !exists {
+			break
+		}
+		cost, _ := entry.tx.Cost()
+		if cost > acc.Balance-spent {
+			break
+		}
+		spent += cost
+		delete(queued, next)
+		pending[next] = entry
+	}
+
+	for nonce, entry := range l.pending {
+		if _, exists := pending[nonce]; !exists && nonce > acc.Nonce {
+			demoted = append(demoted, entry)
+		}
+	}
+	l.pending, l.queued = pending, queued
+	return stale, demoted
+}
@@ -0,0 +1,69 @@
+package miner
+
+import (
+	"context"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// DevSealer produces blocks on demand for development chains running an
+// instant-seal engine: one as soon as a transaction enters the pool, or one
+// every Interval, empty or not, when it is set.
+type DevSealer struct {
+	chain   *blockchain.Blockchain
+	address string
+
+	Interval time.Duration
+	OnBlock  func(block *types.Block) // Called for every block sealed
+}
+
+func NewDevSealer(chain *blockchain.Blockchain, address string) *DevSealer {
+	return &DevSealer{
+		chain:   chain,
+		address: address,
+	}
+}
+
+// Run seals blocks until ctx is done. It returns the first error met while
+// sealing or importing a block.
+func (d *DevSealer) Run(ctx context.Context) error {
+	var txs <-chan *types.Transaction
+	var tick <-chan time.Time
+	// Transactions pending before the subscription get a block right away
+	pending := false
+	if d.Interval > 0 {
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	} else {
+		var unsubscribe func()
+		txs, unsubscribe = d.chain.Mempool.SubscribeTxs()
+		defer unsubscribe()
+		pending = d.chain.Mempool.PendingCount() > 0
+	}
+
+	for {
+		if !pending {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-txs:
+				drain(txs)
+			case <-tick:
+			}
+		}
+		pending = false
+
+		if err := d.chain.MineBlockContext(ctx, d.address); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if d.OnBlock != nil {
+			d.OnBlock(d.chain.GetLastBlock())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package miner_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/miner"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runDevSealer(t *testing.T, interval time.Duration) (*blockchain.Blockchain, *wallet.Wallet, <-chan *types.Block) {
+	sender := wallet.NewWallet()
+	bc, err := blockchain.NewBlockchain(
+		blockchain.WithConfig(config.Config{InMemory: true}),
+		blockchain.WithEngine(consensus.NewInstantEngine()),
+		blockchain.WithGenesis(&blockchain.Genesis{
+			ChainID:     1337,
+			BlockReward: types.CoinbaseAmount,
+			Alloc:       map[string]uint64{sender.Address: 1000},
+		}),
+	)
+	require.NoError(t, err)
+
+	sealer := miner.NewDevSealer(bc, "dev-miner")
+	sealer.Interval = interval
+	sealed := make(chan *types.Block, 8)
+	sealer.OnBlock = func(block *types.Block) { sealed <- block }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sealer.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+		_ = bc.CloseDB()
+	})
+	return bc, sender, sealed
+}
+
+func TestDevSealer_OnTransaction(t *testing.T) {
+	bc, sender, sealed := runDevSealer(t, 0)
+
+	tx := types.NewTransaction(bc.GenesisConfig().ChainID, sender.Address, "receiver", 100, 1, crypto.PublicKeyToBytes(sender.PublicKey))
+	require.NoError(t, tx.Sign(sender))
+	require.NoError(t, bc.Mempool.AddTx(tx))
+
+	select {
+	case block := <-sealed:
+		assert.Equal(t, uint64(1), block.Header.Index)
+		require.Len(t, block.Transactions, 2)
+		assert.Equal(t, tx.Hash, block.Transactions[1].Hash)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for a sealed block")
+	}
+
+	balance, err := bc.State.GetBalance("receiver")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), balance)
+}
+
+func TestDevSealer_Interval(t *testing.T) {
+	_, _, sealed := runDevSealer(t, 10*time.Millisecond)
+
+	for i := uint64(1); i <= 3; i++ {
+		select {
+		case block := <-sealed:
+			assert.Equal(t, i, block.Header.Index)
+			assert.Len(t, block.Transactions, 1, "Empty blocks are sealed on every tick")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for a sealed block")
+		}
+	}
+}
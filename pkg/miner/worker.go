@@ -0,0 +1,158 @@
+package miner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// Worker mines blocks on top of a chain until stopped. The block being mined
+// is rebuilt whenever the tip changes or a transaction arrives that pays
+// better than what the current block holds.
+type Worker struct {
+	chain   *blockchain.Blockchain
+	address string
+
+	OnBlock  func(block *types.Block) // Called for every block mined and imported
+	OnReject func(err error)          // Called for every mined block the chain turned down
+}
+
+func NewWorker(chain *blockchain.Blockchain, address string) *Worker {
+	return &Worker{
+		chain:   chain,
+		address: address,
+	}
+}
+
+// HashRate returns the hashes per second of the block being mined, or zero
+// if the chain's engine does not hash.
+func (w *Worker) HashRate() float64 {
+	if engine, ok := w.chain.Engine().(interface{ HashRate() float64 }); ok {
+		return engine.HashRate()
+	}
+	return 0
+}
+
+// Run mines until ctx is done. It returns the first error met while building,
+// sealing or importing a block, except for a signer out of turn, which waits
+// for the next block instead, and for a mined block the chain rejects, such
+// as one whose parent went away, which is reported to OnReject before a new
+// block is built.
+func (w *Worker) Run(ctx context.Context) error {
+	heads, unsubscribeHeads := w.chain.SubscribeHeads()
+	defer unsubscribeHeads()
+	txs, unsubscribeTxs := w.chain.Mempool.SubscribeTxs()
+	defer unsubscribeTxs()
+
+	for ctx.Err() == nil {
+		// The template is built from the current tip and pool
+		drain(heads)
+		drain(txs)
+
+		template, err := w.chain.BuildTemplate(w.address)
+		if err != nil {
+			return err
+		}
+		block, err := w.mine(ctx, template, heads, txs)
+		if errors.Is(err, consensus.ErrNotInTurn) {
+			// Another signer seals this height
+			select {
+			case <-heads:
+			case <-ctx.Done():
+			}
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("sealing block %d: %w", template.Header.Index, err)
+		}
+		if block == nil {
+			continue
+		}
+		if err := w.chain.AddBlock(block); err != nil {
+			if !rejected(err) {
+				return fmt.Errorf("importing mined block %d: %w", block.Header.Index, err)
+			}
+			if w.OnReject != nil {
+				w.OnReject(fmt.Errorf("mined block %d rejected: %w", block.Header.Index, err))
+			}
+			continue
+		}
+		if w.OnBlock != nil {
+			w.OnBlock(block)
+		}
+	}
+	return nil
+}
+
+// mine seals template, giving up with a nil block when it is made stale by a
+// new tip or a better paying transaction.
+func (w *Worker) mine(ctx context.Context, template *types.Block, heads <-chan *types.Block, txs <-chan *types.Transaction) (*types.Block, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.chain.Engine().Seal(ctx, template)
+	}()
+
+	for {
+		select {
+		case err := <-done:
+			if errors.Is(err, context.Canceled) {
+				return nil, nil
+			}
+			return template, err
+		case <-heads:
+			cancel()
+		case tx := <-txs:
+			if w.improves(template, tx) {
+				cancel()
+			}
+		}
+	}
+}
+
+// improves reports whether tx is worth rebuilding template for: it has room
+// left, or tx pays more per byte than the cheapest transaction in it.
+func (w *Worker) improves(template *types.Block, tx *types.Transaction) bool {
+	included := template.Transactions[1:]
+	if max := w.chain.Template.MaxTxs; max <= 0 || len(included) < max {
+		return true
+	}
+	for _, other := range included {
+		if feeRate(tx) > feeRate(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// rejected reports whether err is the chain turning a block down rather than
+// failing to store it.
+func rejected(err error) bool {
+	return errors.Is(err, blockchain.ErrInvalidBlock) ||
+		errors.Is(err, blockchain.ErrUnknownParent) ||
+		errors.Is(err, blockchain.ErrBadBlock) ||
+		errors.Is(err, blockchain.ErrKnownBlock)
+}
+
+func feeRate(tx *types.Transaction) float64 {
+	return float64(tx.Fee) / float64(tx.Size())
+}
+
+func drain[T any](ch <-chan T) {
+	for {
+		select {
+		case <-ch:
+		default:
+			return
+		}
+	}
+}
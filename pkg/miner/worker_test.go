@@ -0,0 +1,161 @@
+package miner_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/miner"
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker_Run(t *testing.T) {
+	bc, err := blockchain.NewBlockchain(blockchain.WithConfig(config.Config{InMemory: true}))
+	require.NoError(t, err)
+	defer bc.CloseDB()
+
+	start := bc.GetHeight()
+	worker := miner.NewWorker(bc, "worker-miner")
+	mined := make(chan *types.Block, 8)
+	worker.OnBlock = func(block *types.Block) { mined <- block }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- worker.Run(ctx) }()
+
+	var block *types.Block
+	select {
+	case block = <-mined:
+	case <-time.After(time.Minute):
+		t.Fatal("Timeout waiting for a mined block")
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Worker did not stop")
+	}
+
+	assert.Greater(t, block.Header.Index, start)
+	stored, err := bc.GetBlock(block.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, "worker-miner", stored.Header.Miner)
+	assert.Positive(t, worker.HashRate())
+}
+
+// faultyEngine seals like InstantEngine but fails where told to.
+type faultyEngine struct {
+	*consensus.InstantEngine
+	sealErr     error
+	finalizeErr error
+}
+
+func (e faultyEngine) Seal(ctx context.Context, block *types.Block) error {
+	if e.sealErr != nil {
+		return e.sealErr
+	}
+	return e.InstantEngine.Seal(ctx, block)
+}
+
+func (e faultyEngine) Finalize(chain consensus.ChainReader, st *state.State, block *types.Block) error {
+	return e.finalizeErr
+}
+
+func TestWorker_RunFailures(t *testing.T) {
+	failure := errors.New("engine failure")
+	tests := []struct {
+		name   string
+		engine faultyEngine
+	}{
+		{"Seal Error", faultyEngine{InstantEngine: consensus.NewInstantEngine(), sealErr: failure}},
+		{"Import Error", faultyEngine{InstantEngine: consensus.NewInstantEngine(), finalizeErr: failure}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bc, err := blockchain.NewBlockchain(
+				blockchain.WithConfig(config.Config{InMemory: true}),
+				blockchain.WithEngine(tt.engine),
+			)
+			require.NoError(t, err)
+			defer bc.CloseDB()
+
+			done := make(chan error, 1)
+			go func() { done <- miner.NewWorker(bc, "worker-miner").Run(context.Background()) }()
+
+			select {
+			case err := <-done:
+				assert.ErrorIs(t, err, failure)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Worker kept going after a failure")
+			}
+		})
+	}
+}
+
+// flakyVerifier turns down the first block it is asked to verify.
+type flakyVerifier struct {
+	*consensus.InstantEngine
+	rejected atomic.Bool
+}
+
+func (e *flakyVerifier) VerifyHeader(chain consensus.ChainReader, block, parent *types.Block) error {
+	if e.rejected.CompareAndSwap(false, true) {
+		return consensus.ErrInvalidDifficulty
+	}
+	return e.InstantEngine.VerifyHeader(chain, block, parent)
+}
+
+func TestWorker_RunRejectedBlock(t *testing.T) {
+	bc, err := blockchain.NewBlockchain(
+		blockchain.WithConfig(config.Config{InMemory: true}),
+		blockchain.WithEngine(&flakyVerifier{InstantEngine: consensus.NewInstantEngine()}),
+	)
+	require.NoError(t, err)
+	defer bc.CloseDB()
+
+	worker := miner.NewWorker(bc, "worker-miner")
+	rejections := make(chan error, 8)
+	worker.OnReject = func(err error) { rejections <- err }
+	mined := make(chan *types.Block, 8)
+	worker.OnBlock = func(block *types.Block) { mined <- block }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- worker.Run(ctx) }()
+
+	select {
+	case err := <-rejections:
+		assert.ErrorIs(t, err, blockchain.ErrInvalidBlock)
+		assert.ErrorIs(t, err, consensus.ErrInvalidDifficulty)
+	case err := <-done:
+		t.Fatalf("Worker stopped on a rejected block: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the rejection")
+	}
+	select {
+	case <-mined:
+	case err := <-done:
+		t.Fatalf("Worker stopped on a rejected block: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Worker did not mine after the rejection")
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Worker did not stop")
+	}
+}
@@ -0,0 +1,81 @@
+package network
+
+import (
+	"encoding/json"
+
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+const ProtocolVersion = 1
+
+type MessageType string
+
+const (
+	MsgVersion MessageType = "version"
+	MsgVerack  MessageType = "verack"
+	MsgBlock   MessageType = "block"
+	MsgTx      MessageType = "tx"
+	MsgStatus  MessageType = "status"
+
+	MsgGetHeaders MessageType = "getheaders"
+	MsgHeaders    MessageType = "headers"
+	MsgGetBlocks  MessageType = "getblocks"
+	MsgBlocks     MessageType = "blocks"
+)
+
+type Message struct {
+	Type    MessageType     `json:"type"`
+	ID      uint64          `json:"id,omitempty"` // Matches responses to requests
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type VersionPayload struct {
+	Version     uint32 `json:"version"`
+	NodeID      string `json:"nodeId"`
+	GenesisHash []byte `json:"genesisHash"`
+	Height      uint64 `json:"height"`
+	TipHash     []byte `json:"tipHash"`
+	ListenAddr  string `json:"listenAddr"`
+}
+
+// StatusPayload re-announces a node's tip, so peers that missed a block
+// announcement still notice they are behind.
+type StatusPayload struct {
+	Height  uint64 `json:"height"`
+	TipHash []byte `json:"tipHash"`
+}
+
+// GetHeadersPayload asks for up to Max headers walking back from From
+// (inclusive) towards genesis.
+type GetHeadersPayload struct {
+	From []byte `json:"from"`
+	Max  int    `json:"max"`
+}
+
+// HeadersPayload carries blocks stripped of their transactions, newest first.
+type HeadersPayload struct {
+	Headers []*types.Block `json:"headers"`
+}
+
+type GetBlocksPayload struct {
+	Hashes [][]byte `json:"hashes"`
+}
+
+type BlocksPayload struct {
+	Blocks []*types.Block `json:"blocks"`
+}
+
+func NewMessage(msgType MessageType, payload interface{}) (*Message, error) {
+	if payload == nil {
+		return &Message{Type: msgType}, nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: msgType, Payload: data}, nil
+}
+
+func (m *Message) Decode(v interface{}) error {
+	return json.Unmarshal(m.Payload, v)
+}
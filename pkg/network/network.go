@@ -1 +1,500 @@
-package main
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+const (
+	handshakeTimeout = 5 * time.Second
+	dialTimeout      = 5 * time.Second
+	writeTimeout     = 10 * time.Second
+	defaultMaxPeers  = 25
+
+	defaultStatusInterval = 15 * time.Second
+)
+
+var (
+	ErrGenesisMismatch = errors.New("genesis block mismatch")
+	ErrVersionMismatch = errors.New("protocol version mismatch")
+	ErrSelfConnection  = errors.New("connected to self")
+	ErrDuplicatePeer   = errors.New("peer already connected")
+	ErrTooManyPeers    = errors.New("too many peers")
+)
+
+// Chain is the part of the blockchain the network layer needs to serve,
+// import and announce blocks.
+type Chain interface {
+	GetGenesisBlock() (*types.Block, error)
+	GetLastBlock() *types.Block
+	GetBlock(hash []byte) (*types.Block, error)
+	AddBlock(block *types.Block) error
+	SubscribeHeads() (<-chan *types.Block, func())
+
+	// Engine verifies the consensus fields of downloaded headers.
+	Engine() consensus.Engine
+}
+
+type TxPool interface {
+	AddTx(tx *types.Transaction) error
+	SubscribeTxs() (<-chan *types.Transaction, func())
+}
+
+type Config struct {
+	ListenAddr string
+	Seeds      []string
+	MaxPeers   int
+
+	// StatusInterval is how often the tip is re-announced to every peer,
+	// defaultStatusInterval if zero.
+	StatusInterval time.Duration
+
+	// OnSyncProgress, if set, is called after every step of a chain sync.
+	OnSyncProgress func(SyncProgress)
+}
+
+type Server struct {
+	cfg      Config
+	chain    Chain
+	pool     TxPool
+	nodeID   string
+	genesis  []byte
+	listener net.Listener
+	seen     *seenCache
+
+	mu    sync.RWMutex
+	peers map[string]*Peer
+
+	syncing    atomic.Bool
+	progressMu sync.Mutex
+	progress   SyncProgress
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewServer(cfg Config, chain Chain, pool TxPool) *Server {
+	if cfg.MaxPeers <= 0 {
+		cfg.MaxPeers = defaultMaxPeers
+	}
+	if cfg.StatusInterval <= 0 {
+		cfg.StatusInterval = defaultStatusInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		cfg:    cfg,
+		chain:  chain,
+		pool:   pool,
+		nodeID: newNodeID(),
+		seen:   newSeenCache(maxSeenItems),
+		peers:  make(map[string]*Peer),
+		ctx:    ctx,
+		cancel: cancel,
+		quit:   make(chan struct{}),
+	}
+}
+
+func (s *Server) Start() error {
+	genesis, err := s.chain.GetGenesisBlock()
+	if err != nil {
+		return fmt.Errorf("failed to load genesis block: %w", err)
+	}
+	s.genesis = genesis.Hash
+
+	listener, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	heads, unsubscribeHeads := s.chain.SubscribeHeads()
+	txs, unsubscribeTxs := s.pool.SubscribeTxs()
+	s.wg.Add(3)
+	go s.acceptLoop()
+	go func() {
+		defer unsubscribeHeads()
+		s.announceHeads(heads)
+	}()
+	go func() {
+		defer unsubscribeTxs()
+		s.announceTxs(txs)
+	}()
+
+	for _, seed := range s.cfg.Seeds {
+		if err := s.Connect(seed); err != nil {
+			continue // Seeds may be offline, keep going with the rest
+		}
+	}
+	return nil
+}
+
+func (s *Server) Stop() {
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	close(s.quit)
+	s.cancel()
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+
+	s.mu.Lock()
+	for _, p := range s.peers {
+		p.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.cfg.ListenAddr
+	}
+	return s.listener.Addr().String()
+}
+
+func (s *Server) NodeID() string {
+	return s.nodeID
+}
+
+func (s *Server) Peers() []*Peer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+func (s *Server) PeerCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.peers)
+}
+
+func (s *Server) Connect(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	return s.setupPeer(conn, false)
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				return
+			default:
+				continue
+			}
+		}
+		go func() {
+			_ = s.setupPeer(conn, true)
+		}()
+	}
+}
+
+func (s *Server) setupPeer(conn net.Conn, inbound bool) error {
+	peer := newPeer(conn, inbound)
+	if err := s.handshake(peer); err != nil {
+		peer.Close()
+		return err
+	}
+	if err := s.addPeer(peer); err != nil {
+		peer.Close()
+		return err
+	}
+	go s.readLoop(peer)
+	s.maybeSync(peer)
+	return nil
+}
+
+// handshake exchanges version messages and makes sure both nodes speak the
+// same protocol on the same chain before any gossip happens.
+func (s *Server) handshake(peer *Peer) error {
+	if err := peer.conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+
+	lastBlock := s.chain.GetLastBlock()
+	version, err := NewMessage(MsgVersion, VersionPayload{
+		Version:     ProtocolVersion,
+		NodeID:      s.nodeID,
+		GenesisHash: s.genesis,
+		Height:      lastBlock.Header.Index,
+		TipHash:     lastBlock.Hash,
+		ListenAddr:  s.Addr(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := peer.Send(version); err != nil {
+		return err
+	}
+
+	msg, err := peer.readMessage()
+	if err != nil {
+		return err
+	}
+	if msg.Type != MsgVersion {
+		return fmt.Errorf("unexpected handshake message: %s", msg.Type)
+	}
+	var remote VersionPayload
+	if err := msg.Decode(&remote); err != nil {
+		return err
+	}
+	if remote.Version != ProtocolVersion {
+		return fmt.Errorf("%w: %d, expected: %d", ErrVersionMismatch, remote.Version, ProtocolVersion)
+	}
+	if !bytes.Equal(remote.GenesisHash, s.genesis) {
+		return fmt.Errorf("%w: %x", ErrGenesisMismatch, remote.GenesisHash)
+	}
+	if remote.NodeID == s.nodeID {
+		return ErrSelfConnection
+	}
+	peer.setVersion(remote)
+
+	if err := peer.Send(&Message{Type: MsgVerack}); err != nil {
+		return err
+	}
+	msg, err = peer.readMessage()
+	if err != nil {
+		return err
+	}
+	if msg.Type != MsgVerack {
+		return fmt.Errorf("unexpected handshake message: %s", msg.Type)
+	}
+
+	return peer.conn.SetDeadline(time.Time{})
+}
+
+func (s *Server) addPeer(peer *Peer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.quit:
+		return fmt.Errorf("server stopped")
+	default:
+	}
+	if _, exists := s.peers[peer.NodeID()]; exists {
+		return ErrDuplicatePeer
+	}
+	if len(s.peers) >= s.cfg.MaxPeers {
+		return ErrTooManyPeers
+	}
+	s.peers[peer.NodeID()] = peer
+	s.wg.Add(1) // Released by readLoop
+	return nil
+}
+
+// goTracked runs fn in a goroutine that Stop waits for, unless the server is
+// already shutting down.
+func (s *Server) goTracked(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.quit:
+		return
+	default:
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+}
+
+func (s *Server) removePeer(peer *Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, exists := s.peers[peer.NodeID()]; exists && current == peer {
+		delete(s.peers, peer.NodeID())
+	}
+}
+
+func (s *Server) readLoop(peer *Peer) {
+	defer s.wg.Done()
+	defer s.removePeer(peer)
+	defer peer.Close()
+
+	for {
+		msg, err := peer.readMessage()
+		if err != nil {
+			return
+		}
+		if err := s.handleMessage(peer, msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handleMessage(peer *Peer, msg *Message) error {
+	switch msg.Type {
+	case MsgBlock:
+		block, err := types.DeserializeBlock(msg.Payload)
+		if err != nil {
+			return err
+		}
+		s.handleBlock(peer, block)
+	case MsgTx:
+		var tx types.Transaction
+		if err := msg.Decode(&tx); err != nil {
+			return err
+		}
+		s.handleTx(peer, &tx)
+	case MsgStatus:
+		var status StatusPayload
+		if err := msg.Decode(&status); err != nil {
+			return err
+		}
+		peer.updateTip(status.Height, status.TipHash)
+		s.maybeSync(peer)
+	case MsgGetHeaders:
+		return s.handleGetHeaders(peer, msg)
+	case MsgGetBlocks:
+		return s.handleGetBlocks(peer, msg)
+	case MsgHeaders, MsgBlocks:
+		peer.deliver(msg)
+	default:
+		return fmt.Errorf("unknown message type: %s", msg.Type)
+	}
+	return nil
+}
+
+func (s *Server) handleBlock(peer *Peer, block *types.Block) {
+	peer.updateTip(block.Header.Index, block.Hash)
+	if !s.seen.add(block.Hash) {
+		return
+	}
+	if err := s.chain.AddBlock(block); err != nil {
+		// We are probably missing its parents, catch up with the sender
+		s.maybeSync(peer)
+		return
+	}
+	s.relay(MsgBlock, block, peer)
+}
+
+func (s *Server) handleTx(peer *Peer, tx *types.Transaction) {
+	if !s.seen.add(tx.Hash) {
+		return
+	}
+	if err := s.pool.AddTx(tx); err != nil {
+		return
+	}
+	s.relay(MsgTx, tx, peer)
+}
+
+// BroadcastBlock announces a block to every peer, unless it was already
+// gossiped.
+func (s *Server) BroadcastBlock(block *types.Block) {
+	if s.seen.add(block.Hash) {
+		s.relay(MsgBlock, block, nil)
+	}
+}
+
+// BroadcastTx announces a transaction to every peer, unless it was already
+// gossiped.
+func (s *Server) BroadcastTx(tx *types.Transaction) {
+	if s.seen.add(tx.Hash) {
+		s.relay(MsgTx, tx, nil)
+	}
+}
+
+// announceHeads gossips the tip whenever it moves, and re-announces it as a
+// status every StatusInterval. Head events are dropped while we are busy, so
+// the current tip is sent rather than the event's block: peers missing the
+// blocks in between catch up through sync.
+func (s *Server) announceHeads(heads <-chan *types.Block) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.StatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-heads:
+			s.BroadcastBlock(s.chain.GetLastBlock())
+		case <-ticker.C:
+			tip := s.chain.GetLastBlock()
+			s.relay(MsgStatus, StatusPayload{Height: tip.Header.Index, TipHash: tip.Hash}, nil)
+		}
+	}
+}
+
+// announceTxs gossips admitted transactions. They are queued as soon as they
+// arrive and sent by a separate goroutine, so slow peers do not make the
+// subscription drop any.
+func (s *Server) announceTxs(txs <-chan *types.Transaction) {
+	defer s.wg.Done()
+
+	outgoing := make(chan *types.Transaction)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for tx := range outgoing {
+			s.BroadcastTx(tx)
+		}
+	}()
+	defer close(outgoing)
+
+	var queue []*types.Transaction
+	for {
+		var send chan<- *types.Transaction
+		var next *types.Transaction
+		if len(queue) > 0 {
+			send, next = outgoing, queue[0]
+		}
+		select {
+		case <-s.quit:
+			return
+		case tx := <-txs:
+			queue = append(queue, tx)
+		case send <- next:
+			queue = queue[1:]
+		}
+	}
+}
+
+func (s *Server) relay(msgType MessageType, payload interface{}, except *Peer) {
+	msg, err := NewMessage(msgType, payload)
+	if err != nil {
+		return
+	}
+	for _, p := range s.Peers() {
+		if p == except {
+			continue
+		}
+		if err := p.Send(msg); err != nil {
+			p.Close()
+		}
+	}
+}
+
+func newNodeID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,427 @@
+package network_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/blockchain"
+	"github.com/karimseh/gochain/pkg/config"
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/network"
+	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/types"
+	"github.com/karimseh/gochain/pkg/wallet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feed fans values out to subscribers like the chain and pool events do.
+type feed[T any] struct {
+	mu   sync.Mutex
+	subs map[chan T]struct{}
+}
+
+func (f *feed[T]) subscribe() (<-chan T, func()) {
+	ch := make(chan T, 16)
+	f.mu.Lock()
+	if f.subs == nil {
+		f.subs = make(map[chan T]struct{})
+	}
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subs, ch)
+		f.mu.Unlock()
+	}
+}
+
+func (f *feed[T]) send(v T) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- v:
+		default:
+		}
+	}
+}
+
+type memChain struct {
+	mu     sync.RWMutex
+	blocks []*types.Block
+	heads  feed[*types.Block]
+}
+
+func newMemChain(genesis *types.Block) *memChain {
+	return &memChain{blocks: []*types.Block{genesis}}
+}
+
+func (c *memChain) GetGenesisBlock() (*types.Block, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blocks[0], nil
+}
+
+func (c *memChain) GetLastBlock() *types.Block {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blocks[len(c.blocks)-1]
+}
+
+func (c *memChain) GetBlock(hash []byte) (*types.Block, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, block := range c.blocks {
+		if bytes.Equal(block.Hash, hash) {
+			return block, nil
+		}
+	}
+	return nil, fmt.Errorf("block not found")
+}
+
+func (c *memChain) AddBlock(block *types.Block) error {
+	if err := c.addSilently(block); err != nil {
+		return err
+	}
+	c.heads.send(block)
+	return nil
+}
+
+// addSilently extends the chain without a head event, like one dropped by a
+// busy subscriber.
+func (c *memChain) addSilently(block *types.Block) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := c.blocks[len(c.blocks)-1]
+	if !bytes.Equal(block.Header.ParentHash, last.Hash) {
+		return fmt.Errorf("invalid parent hash")
+	}
+	if err := block.Validate(); err != nil {
+		return err
+	}
+	c.blocks = append(c.blocks, block)
+	return nil
+}
+
+func (c *memChain) SubscribeHeads() (<-chan *types.Block, func()) {
+	return c.heads.subscribe()
+}
+
+func (c *memChain) Engine() consensus.Engine {
+	return testEngine{}
+}
+
+func (c *memChain) Height() uint64 {
+	return c.GetLastBlock().Header.Index
+}
+
+type memPool struct {
+	mu    sync.Mutex
+	txs   map[string]*types.Transaction
+	added feed[*types.Transaction]
+}
+
+func newMemPool() *memPool {
+	return &memPool{txs: make(map[string]*types.Transaction)}
+}
+
+func (p *memPool) AddTx(tx *types.Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !tx.Verify() {
+		return fmt.Errorf("transaction signature verification failed")
+	}
+	p.txs[hex.EncodeToString(tx.Hash)] = tx
+	p.added.send(tx)
+	return nil
+}
+
+func (p *memPool) SubscribeTxs() (<-chan *types.Transaction, func()) {
+	return p.added.subscribe()
+}
+
+func (p *memPool) Has(tx *types.Transaction) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, exists := p.txs[hex.EncodeToString(tx.Hash)]
+	return exists
+}
+
+func testGenesis(miner string) *types.Block {
+	genesis := types.NewBlock(0, []*types.Transaction{}, []byte{}, miner)
+	genesis.Header.Timestamp = 1
+	genesis.Hash = genesis.CalculateHash()
+	return genesis
+}
+
+// testEngine expects the fixed difficulty mineTestBlock seals at.
+type testEngine struct{}
+
+func (testEngine) Prepare(chain consensus.ChainReader, header *types.BlockHeader, parent *types.Block) error {
+	header.Difficulty = testDifficulty
+	return nil
+}
+
+func (testEngine) Seal(ctx context.Context, block *types.Block) error {
+	return errors.New("test engine cannot seal")
+}
+
+func (testEngine) VerifyHeader(chain consensus.ChainReader, block, parent *types.Block) error {
+	if block.Header.Difficulty != testDifficulty {
+		return fmt.Errorf("%w: %d, expected: %d", consensus.ErrInvalidDifficulty, block.Header.Difficulty, testDifficulty)
+	}
+	if !crypto.ValidateHash(block.Hash, block.Header.Difficulty) {
+		return consensus.ErrInvalidProofOfWork
+	}
+	return nil
+}
+
+func (testEngine) Finalize(chain consensus.ChainReader, st *state.State, block *types.Block) error {
+	return nil
+}
+
+const testDifficulty = 4
+
+// mineTestBlock grinds a low difficulty so tests don't burn CPU.
+func mineTestBlock(parent *types.Block) *types.Block {
+	block := types.NewBlock(
+		parent.Header.Index+1,
+		[]*types.Transaction{types.NewCoinbaseTx("miner")},
+		parent.Hash,
+		"miner",
+	)
+	block.Header.Difficulty = testDifficulty
+	for nonce := uint64(0); ; nonce++ {
+		block.Header.Nonce = nonce
+		hash := block.CalculateHash()
+		if crypto.ValidateHash(hash, block.Header.Difficulty) {
+			block.Hash = hash
+			return block
+		}
+	}
+}
+
+// extendChain mines n blocks on top of the chain's tip.
+func (c *memChain) extendChain(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		require.NoError(t, c.AddBlock(mineTestBlock(c.GetLastBlock())))
+	}
+}
+
+type testNode struct {
+	server *network.Server
+	chain  *memChain
+	pool   *memPool
+}
+
+func startNode(t *testing.T, genesis *types.Block, seeds ...string) *testNode {
+	return startNodeWithChain(t, newMemChain(genesis), network.Config{Seeds: seeds})
+}
+
+func startNodeWithChain(t *testing.T, chain *memChain, cfg network.Config) *testNode {
+	pool := newMemPool()
+	cfg.ListenAddr = "127.0.0.1:0"
+	server := network.NewServer(cfg, chain, pool)
+	require.NoError(t, server.Start())
+	t.Cleanup(server.Stop)
+	return &testNode{server: server, chain: chain, pool: pool}
+}
+
+func TestServer_Handshake(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	t.Run("Same Genesis", func(t *testing.T) {
+		a := startNode(t, genesis)
+		b := startNode(t, genesis, a.server.Addr())
+
+		require.Eventually(t, func() bool {
+			return a.server.PeerCount() == 1 && b.server.PeerCount() == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		assert.Equal(t, a.server.NodeID(), b.server.Peers()[0].NodeID())
+	})
+
+	t.Run("Genesis Mismatch", func(t *testing.T) {
+		a := startNode(t, genesis)
+		b := startNode(t, testGenesis("OTHER"))
+
+		err := b.server.Connect(a.server.Addr())
+		assert.ErrorIs(t, err, network.ErrGenesisMismatch)
+		assert.Zero(t, b.server.PeerCount())
+	})
+
+	t.Run("Self Connection", func(t *testing.T) {
+		a := startNode(t, genesis)
+		err := a.server.Connect(a.server.Addr())
+		assert.ErrorIs(t, err, network.ErrSelfConnection)
+	})
+
+	t.Run("Reports Height", func(t *testing.T) {
+		a := startNode(t, genesis)
+		require.NoError(t, a.chain.AddBlock(mineTestBlock(genesis)))
+
+		b := startNode(t, genesis, a.server.Addr())
+		require.Equal(t, 1, b.server.PeerCount())
+		assert.Equal(t, uint64(1), b.server.Peers()[0].Height())
+	})
+}
+
+func TestServer_BlockGossip(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	// a <-> b <-> c, so c only hears about a's blocks through b
+	a := startNode(t, genesis)
+	b := startNode(t, genesis, a.server.Addr())
+	c := startNode(t, genesis, b.server.Addr())
+
+	require.Eventually(t, func() bool {
+		return a.server.PeerCount() == 1 && b.server.PeerCount() == 2 && c.server.PeerCount() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	block := mineTestBlock(genesis)
+	require.NoError(t, a.chain.AddBlock(block))
+	a.server.BroadcastBlock(block)
+
+	require.Eventually(t, func() bool {
+		return b.chain.Height() == 1 && c.chain.Height() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, block.Hash, c.chain.GetLastBlock().Hash)
+	assert.Equal(t, uint64(1), a.chain.Height(), "Block should not be imported twice")
+}
+
+func TestServer_AnnouncesLocalWork(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	a := startNode(t, genesis)
+	b := startNode(t, genesis, a.server.Addr())
+
+	require.Eventually(t, func() bool {
+		return a.server.PeerCount() == 1 && b.server.PeerCount() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	t.Run("Mined Block", func(t *testing.T) {
+		block := mineTestBlock(genesis)
+		require.NoError(t, a.chain.AddBlock(block))
+
+		require.Eventually(t, func() bool {
+			return bytes.Equal(block.Hash, b.chain.GetLastBlock().Hash)
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("Submitted Transaction", func(t *testing.T) {
+		w := wallet.NewWallet()
+		tx := types.NewTransaction(0, w.Address, "recipient", 10, 1, crypto.PublicKeyToBytes(w.PublicKey))
+		require.NoError(t, tx.Sign(w))
+		require.NoError(t, b.pool.AddTx(tx))
+
+		require.Eventually(t, func() bool {
+			return a.pool.Has(tx)
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestServer_FollowsMiningBurst(t *testing.T) {
+	genesis := &blockchain.Genesis{ChainID: 7, Timestamp: 1_700_000_000, BlockReward: types.CoinbaseAmount}
+	newChain := func() *blockchain.Blockchain {
+		bc, err := blockchain.NewBlockchain(
+			blockchain.WithConfig(config.Config{InMemory: true}),
+			blockchain.WithEngine(consensus.NewInstantEngine()),
+			blockchain.WithGenesis(genesis),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = bc.CloseDB() })
+		return bc
+	}
+
+	chainA, chainB := newChain(), newChain()
+	a := network.NewServer(network.Config{ListenAddr: "127.0.0.1:0"}, chainA, chainA.Mempool)
+	require.NoError(t, a.Start())
+	t.Cleanup(a.Stop)
+	b := network.NewServer(network.Config{ListenAddr: "127.0.0.1:0", Seeds: []string{a.Addr()}}, chainB, chainB.Mempool)
+	require.NoError(t, b.Start())
+	t.Cleanup(b.Stop)
+	require.Equal(t, 1, b.PeerCount())
+
+	for range 20 {
+		require.NoError(t, chainA.MineBlock("miner"))
+	}
+
+	require.Eventually(t, func() bool {
+		return chainB.GetHeight() == chainA.GetHeight()
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, chainA.LastHash, chainB.LastHash)
+}
+
+func TestServer_StatusRecoversMissedAnnouncement(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	a := startNodeWithChain(t, newMemChain(genesis), network.Config{StatusInterval: 50 * time.Millisecond})
+	b := startNode(t, genesis, a.server.Addr())
+	require.Eventually(t, func() bool {
+		return a.server.PeerCount() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	block := mineTestBlock(genesis)
+	require.NoError(t, a.chain.addSilently(block))
+
+	require.Eventually(t, func() bool {
+		return b.chain.Height() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, block.Hash, b.chain.GetLastBlock().Hash)
+}
+
+func TestServer_TxGossip(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	a := startNode(t, genesis)
+	b := startNode(t, genesis, a.server.Addr())
+	c := startNode(t, genesis, b.server.Addr())
+
+	require.Eventually(t, func() bool {
+		return b.server.PeerCount() == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	w := wallet.NewWallet()
+	tx := types.NewTransaction(0, w.Address, "recipient", 10, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	require.NoError(t, tx.Sign(w))
+
+	require.NoError(t, c.pool.AddTx(tx))
+	c.server.BroadcastTx(tx)
+
+	require.Eventually(t, func() bool {
+		return a.pool.Has(tx) && b.pool.Has(tx)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestServer_InvalidBlockNotRelayed(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	a := startNode(t, genesis)
+	b := startNode(t, genesis, a.server.Addr())
+	c := startNode(t, genesis, b.server.Addr())
+
+	require.Eventually(t, func() bool {
+		return b.server.PeerCount() == 2
+	}, 2*time.Second, 10*time.Millisecond)
+
+	block := mineTestBlock(genesis)
+	block.Hash[0]++ // Tamper with the block
+	a.server.BroadcastBlock(block)
+
+	valid := mineTestBlock(genesis)
+	a.server.BroadcastBlock(valid)
+
+	require.Eventually(t, func() bool {
+		return c.chain.Height() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, valid.Hash, b.chain.GetLastBlock().Hash)
+	assert.Equal(t, valid.Hash, c.chain.GetLastBlock().Hash)
+}
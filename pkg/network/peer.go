@@ -0,0 +1,148 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrPeerClosed = errors.New("peer connection closed")
+
+type Peer struct {
+	conn      net.Conn
+	enc       *json.Encoder
+	dec       *json.Decoder
+	writeMu   sync.Mutex
+	inbound   bool
+	mu        sync.RWMutex
+	version   VersionPayload
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	nextID    atomic.Uint64
+	pendingMu sync.Mutex
+	pending   map[uint64]chan *Message
+}
+
+func newPeer(conn net.Conn, inbound bool) *Peer {
+	return &Peer{
+		conn:    conn,
+		enc:     json.NewEncoder(conn),
+		dec:     json.NewDecoder(conn),
+		inbound: inbound,
+		closed:  make(chan struct{}),
+		pending: make(map[uint64]chan *Message),
+	}
+}
+
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+func (p *Peer) Inbound() bool {
+	return p.inbound
+}
+
+func (p *Peer) NodeID() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.version.NodeID
+}
+
+func (p *Peer) Height() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.version.Height
+}
+
+func (p *Peer) TipHash() []byte {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.version.TipHash
+}
+
+func (p *Peer) setVersion(v VersionPayload) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.version = v
+}
+
+// updateTip records a block announced by the peer if it extends what we knew.
+func (p *Peer) updateTip(height uint64, hash []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if height > p.version.Height {
+		p.version.Height = height
+		p.version.TipHash = hash
+	}
+}
+
+func (p *Peer) Send(msg *Message) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if err := p.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	return p.enc.Encode(msg)
+}
+
+// request sends msg and waits for the response carrying the same ID.
+func (p *Peer) request(ctx context.Context, msg *Message) (*Message, error) {
+	id := p.nextID.Add(1)
+	msg.ID = id
+
+	ch := make(chan *Message, 1)
+	p.pendingMu.Lock()
+	p.pending[id] = ch
+	p.pendingMu.Unlock()
+	defer func() {
+		p.pendingMu.Lock()
+		delete(p.pending, id)
+		p.pendingMu.Unlock()
+	}()
+
+	if err := p.Send(msg); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-p.closed:
+		return nil, ErrPeerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// deliver hands a response to the request waiting for it, if any.
+func (p *Peer) deliver(msg *Message) bool {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	ch, exists := p.pending[msg.ID]
+	if !exists {
+		return false
+	}
+	ch <- msg
+	delete(p.pending, msg.ID)
+	return true
+}
+
+func (p *Peer) readMessage() (*Message, error) {
+	var msg Message
+	if err := p.dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		_ = p.conn.Close()
+	})
+}
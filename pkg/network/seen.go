@@ -0,0 +1,43 @@
+package network
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+const maxSeenItems = 4096
+
+// seenCache remembers recently gossiped hashes so blocks and transactions are
+// relayed once instead of bouncing around the network forever.
+type seenCache struct {
+	mu    sync.Mutex
+	max   int
+	items map[string]struct{}
+	order []string
+}
+
+func newSeenCache(max int) *seenCache {
+	return &seenCache{
+		max:   max,
+		items: make(map[string]struct{}),
+	}
+}
+
+// add returns false if the hash was already known.
+func (c *seenCache) add(hash []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	if _, exists := c.items[key]; exists {
+		return false
+	}
+	if len(c.order) >= c.max {
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		delete(c.items, oldest)
+	}
+	c.items[key] = struct{}{}
+	c.order = append(c.order, key)
+	return true
+}
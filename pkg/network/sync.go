@@ -0,0 +1,387 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+const (
+	maxHeadersPerRequest = 256
+	maxBlocksPerRequest  = 32
+	maxParallelDownloads = 4
+	requestTimeout       = 10 * time.Second
+)
+
+var (
+	ErrSyncInProgress     = errors.New("sync already in progress")
+	ErrInvalidHeaderChain = errors.New("invalid header chain")
+	ErrNoCommonAncestor   = errors.New("no common ancestor with peer")
+)
+
+type SyncProgress struct {
+	Peer          string
+	StartHeight   uint64
+	CurrentHeight uint64
+	TargetHeight  uint64
+	Headers       int
+	Done          bool
+}
+
+func (s *Server) SyncProgress() SyncProgress {
+	s.progressMu.Lock()
+	defer s.progressMu.Unlock()
+	return s.progress
+}
+
+func (s *Server) setProgress(update func(p *SyncProgress)) {
+	s.progressMu.Lock()
+	update(&s.progress)
+	progress := s.progress
+	s.progressMu.Unlock()
+
+	if s.cfg.OnSyncProgress != nil {
+		s.cfg.OnSyncProgress(progress)
+	}
+}
+
+// Sync catches the local chain up with peer. Headers are fetched from the
+// peer's tip back to the first block we already know, checked for parent
+// links and by the chain's consensus engine, then bodies are downloaded in parallel and
+// imported in order through Chain.AddBlock.
+func (s *Server) Sync(ctx context.Context, peer *Peer) error {
+	if !s.syncing.CompareAndSwap(false, true) {
+		return ErrSyncInProgress
+	}
+	defer s.syncing.Store(false)
+
+	local := s.chain.GetLastBlock()
+	if peer.Height() <= local.Header.Index {
+		return nil
+	}
+
+	headers, ancestor, err := s.fetchHeaders(ctx, peer)
+	if err != nil {
+		return err
+	}
+	if err := s.validateHeaderChain(ancestor, headers); err != nil {
+		return err
+	}
+
+	s.setProgress(func(p *SyncProgress) {
+		*p = SyncProgress{
+			Peer:          peer.Addr(),
+			StartHeight:   ancestor.Header.Index,
+			CurrentHeight: ancestor.Header.Index,
+			TargetHeight:  headers[len(headers)-1].Header.Index,
+			Headers:       len(headers),
+		}
+	})
+
+	if err := s.downloadBlocks(ctx, peer, headers); err != nil {
+		return err
+	}
+
+	s.setProgress(func(p *SyncProgress) {
+		p.Done = true
+	})
+	return nil
+}
+
+// maybeSync starts syncing with peer if it is ahead. Tips the peer announces
+// during a sync are ignored by it, so syncing goes on until we caught up or
+// stop making progress.
+func (s *Server) maybeSync(peer *Peer) {
+	if peer.Height() <= s.chain.GetLastBlock().Header.Index {
+		return
+	}
+	s.goTracked(func() {
+		for {
+			height := s.chain.GetLastBlock().Header.Index
+			if peer.Height() <= height {
+				return
+			}
+			if err := s.Sync(s.ctx, peer); err != nil {
+				return
+			}
+			if s.chain.GetLastBlock().Header.Index <= height {
+				return
+			}
+		}
+	})
+}
+
+// fetchHeaders walks the peer's chain backwards from its tip until it reaches
+// a block we already have. Headers are returned oldest first along with that
+// common ancestor.
+func (s *Server) fetchHeaders(ctx context.Context, peer *Peer) ([]*types.Block, *types.Block, error) {
+	var headers []*types.Block
+	cursor := peer.TipHash()
+	limit := peer.Height() + 1
+
+	for uint64(len(headers)) <= limit {
+		resp, err := s.request(ctx, peer, MsgGetHeaders, GetHeadersPayload{From: cursor, Max: maxHeadersPerRequest})
+		if err != nil {
+			return nil, nil, err
+		}
+		var payload HeadersPayload
+		if err := resp.Decode(&payload); err != nil {
+			return nil, nil, err
+		}
+		if len(payload.Headers) == 0 {
+			return nil, nil, fmt.Errorf("peer returned no headers for %x", cursor)
+		}
+
+		for _, header := range payload.Headers {
+			if !bytes.Equal(header.Hash, cursor) {
+				return nil, nil, fmt.Errorf("%w: unexpected header %x, expected: %x", ErrInvalidHeaderChain, header.Hash, cursor)
+			}
+			if ancestor, err := s.chain.GetBlock(header.Hash); err == nil {
+				reverseBlocks(headers)
+				return headers, ancestor, nil
+			}
+			if header.Header.Index == 0 {
+				return nil, nil, ErrNoCommonAncestor
+			}
+			headers = append(headers, header)
+			cursor = header.Header.ParentHash
+		}
+	}
+	return nil, nil, ErrNoCommonAncestor
+}
+
+// validateHeaderChain checks that headers link up from ancestor and passes
+// each of them to the chain's engine. Engines needing the bodies of earlier
+// headers stop being asked from there on; those blocks are still verified in
+// full when imported.
+func (s *Server) validateHeaderChain(ancestor *types.Block, headers []*types.Block) error {
+	if len(headers) == 0 {
+		return fmt.Errorf("%w: empty", ErrInvalidHeaderChain)
+	}
+	engine := s.chain.Engine()
+	reader := &headerReader{chain: s.chain, headers: make(map[string]*types.Block, len(headers))}
+	verifying := true
+	parent := ancestor
+	for _, header := range headers {
+		if header.Header.Index != parent.Header.Index+1 {
+			return fmt.Errorf("%w: invalid index %d, expected: %d", ErrInvalidHeaderChain, header.Header.Index, parent.Header.Index+1)
+		}
+		if !bytes.Equal(header.Header.ParentHash, parent.Hash) {
+			return fmt.Errorf("%w: broken parent link at %d", ErrInvalidHeaderChain, header.Header.Index)
+		}
+		if !bytes.Equal(header.CalculateHash(), header.Hash) {
+			return fmt.Errorf("%w: invalid hash at %d", ErrInvalidHeaderChain, header.Header.Index)
+		}
+		if verifying {
+			err := engine.VerifyHeader(reader, header, parent)
+			if errors.Is(err, consensus.ErrMissingBodies) {
+				verifying = false
+			} else if err != nil {
+				return fmt.Errorf("%w: block %d: %w", ErrInvalidHeaderChain, header.Header.Index, err)
+			}
+		}
+		reader.headers[string(header.Hash)] = header
+		parent = header
+	}
+	return nil
+}
+
+// headerReader lets the engine look up downloaded headers as if they were
+// part of the chain.
+type headerReader struct {
+	chain   Chain
+	headers map[string]*types.Block
+}
+
+func (r *headerReader) GetBlock(hash []byte) (*types.Block, error) {
+	if header, exists := r.headers[string(hash)]; exists {
+		return header, nil
+	}
+	return r.chain.GetBlock(hash)
+}
+
+type blockBatch struct {
+	headers []*types.Block
+	blocks  []*types.Block
+	err     error
+	done    chan struct{}
+}
+
+// downloadBlocks fetches bodies for headers from several peers at once while
+// importing finished batches strictly in chain order.
+func (s *Server) downloadBlocks(ctx context.Context, peer *Peer, headers []*types.Block) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var batches []*blockBatch
+	for start := 0; start < len(headers); start += maxBlocksPerRequest {
+		end := min(start+maxBlocksPerRequest, len(headers))
+		batches = append(batches, &blockBatch{headers: headers[start:end], done: make(chan struct{})})
+	}
+
+	go func() {
+		sem := make(chan struct{}, maxParallelDownloads)
+		for i, batch := range batches {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			source := s.downloadPeer(peer, i, batch)
+			go func() {
+				defer func() { <-sem }()
+				batch.blocks, batch.err = s.fetchBlocks(ctx, source, batch.headers)
+				if batch.err != nil && source != peer {
+					batch.blocks, batch.err = s.fetchBlocks(ctx, peer, batch.headers)
+				}
+				close(batch.done)
+			}()
+		}
+	}()
+
+	for _, batch := range batches {
+		select {
+		case <-batch.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if batch.err != nil {
+			return batch.err
+		}
+		for _, block := range batch.blocks {
+			if err := s.chain.AddBlock(block); err != nil {
+				return fmt.Errorf("failed to import block %d: %w", block.Header.Index, err)
+			}
+			s.seen.add(block.Hash)
+			s.setProgress(func(p *SyncProgress) {
+				p.CurrentHeight = block.Header.Index
+			})
+		}
+	}
+	return nil
+}
+
+// downloadPeer spreads batches over every peer that claims to have them,
+// falling back to the peer we are syncing from.
+func (s *Server) downloadPeer(syncPeer *Peer, batchIndex int, batch *blockBatch) *Peer {
+	last := batch.headers[len(batch.headers)-1].Header.Index
+	candidates := []*Peer{syncPeer}
+	for _, p := range s.Peers() {
+		if p != syncPeer && p.Height() >= last {
+			candidates = append(candidates, p)
+		}
+	}
+	return candidates[batchIndex%len(candidates)]
+}
+
+func (s *Server) fetchBlocks(ctx context.Context, peer *Peer, headers []*types.Block) ([]*types.Block, error) {
+	hashes := make([][]byte, len(headers))
+	for i, header := range headers {
+		hashes[i] = header.Hash
+	}
+	resp, err := s.request(ctx, peer, MsgGetBlocks, GetBlocksPayload{Hashes: hashes})
+	if err != nil {
+		return nil, err
+	}
+	var payload BlocksPayload
+	if err := resp.Decode(&payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Blocks) != len(headers) {
+		return nil, fmt.Errorf("peer returned %d blocks, expected: %d", len(payload.Blocks), len(headers))
+	}
+	for i, block := range payload.Blocks {
+		if !bytes.Equal(block.Hash, headers[i].Hash) || !bytes.Equal(block.CalculateHash(), block.Hash) {
+			return nil, fmt.Errorf("peer returned unexpected block %x", block.Hash)
+		}
+		if !types.VerifyMerkleRoot(block.Transactions, block.MerkleRoot) {
+			return nil, fmt.Errorf("invalid merkle root for block %x", block.Hash)
+		}
+	}
+	return payload.Blocks, nil
+}
+
+func (s *Server) request(ctx context.Context, peer *Peer, msgType MessageType, payload interface{}) (*Message, error) {
+	msg, err := NewMessage(msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return peer.request(ctx, msg)
+}
+
+func (s *Server) handleGetHeaders(peer *Peer, msg *Message) error {
+	var req GetHeadersPayload
+	if err := msg.Decode(&req); err != nil {
+		return err
+	}
+	max := req.Max
+	if max <= 0 || max > maxHeadersPerRequest {
+		max = maxHeadersPerRequest
+	}
+
+	var headers []*types.Block
+	hash := req.From
+	for len(headers) < max {
+		block, err := s.chain.GetBlock(hash)
+		if err != nil {
+			break
+		}
+		headers = append(headers, headerOnly(block))
+		if block.Header.Index == 0 {
+			break
+		}
+		hash = block.Header.ParentHash
+	}
+	return s.respond(peer, msg, MsgHeaders, HeadersPayload{Headers: headers})
+}
+
+func (s *Server) handleGetBlocks(peer *Peer, msg *Message) error {
+	var req GetBlocksPayload
+	if err := msg.Decode(&req); err != nil {
+		return err
+	}
+	if len(req.Hashes) > maxBlocksPerRequest {
+		return fmt.Errorf("too many blocks requested: %d", len(req.Hashes))
+	}
+
+	blocks := make([]*types.Block, 0, len(req.Hashes))
+	for _, hash := range req.Hashes {
+		block, err := s.chain.GetBlock(hash)
+		if err != nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	return s.respond(peer, msg, MsgBlocks, BlocksPayload{Blocks: blocks})
+}
+
+func (s *Server) respond(peer *Peer, req *Message, msgType MessageType, payload interface{}) error {
+	resp, err := NewMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+	resp.ID = req.ID
+	return peer.Send(resp)
+}
+
+func headerOnly(block *types.Block) *types.Block {
+	return &types.Block{
+		Header:     block.Header,
+		MerkleRoot: block.MerkleRoot,
+		StateRoot:  block.StateRoot,
+		Hash:       block.Hash,
+	}
+}
+
+func reverseBlocks(blocks []*types.Block) {
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+}
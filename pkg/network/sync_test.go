@@ -0,0 +1,130 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimseh/gochain/pkg/consensus"
+	"github.com/karimseh/gochain/pkg/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_InitialSync(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	source := newMemChain(genesis)
+	source.extendChain(t, 70)
+	a := startNodeWithChain(t, source, network.Config{})
+
+	var mu sync.Mutex
+	var updates []network.SyncProgress
+	b := startNodeWithChain(t, newMemChain(genesis), network.Config{
+		Seeds: []string{a.server.Addr()},
+		OnSyncProgress: func(p network.SyncProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			updates = append(updates, p)
+		},
+	})
+
+	require.Eventually(t, func() bool {
+		return b.chain.Height() == 70
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, source.GetLastBlock().Hash, b.chain.GetLastBlock().Hash)
+
+	require.Eventually(t, func() bool {
+		return b.server.SyncProgress().Done
+	}, time.Second, 10*time.Millisecond)
+	progress := b.server.SyncProgress()
+	assert.Equal(t, uint64(0), progress.StartHeight)
+	assert.Equal(t, uint64(70), progress.CurrentHeight)
+	assert.Equal(t, uint64(70), progress.TargetHeight)
+	assert.Equal(t, 70, progress.Headers)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Greater(t, len(updates), 2, "Progress should be reported while importing")
+}
+
+func TestServer_SyncFromCommonAncestor(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	source := newMemChain(genesis)
+	source.extendChain(t, 10)
+
+	// The lagging node already shares the first 4 blocks
+	lagging := newMemChain(genesis)
+	for _, block := range source.blocks[1:5] {
+		require.NoError(t, lagging.AddBlock(block))
+	}
+
+	a := startNodeWithChain(t, source, network.Config{})
+	b := startNodeWithChain(t, lagging, network.Config{Seeds: []string{a.server.Addr()}})
+
+	require.Eventually(t, func() bool {
+		return b.server.SyncProgress().Done
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(10), b.chain.Height())
+	assert.Equal(t, uint64(4), b.server.SyncProgress().StartHeight)
+	assert.Equal(t, 6, b.server.SyncProgress().Headers)
+}
+
+func TestServer_SyncRejectsInvalidHeaders(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	source := newMemChain(genesis)
+	source.extendChain(t, 3)
+
+	// Claim more work than the hash actually carries
+	forged := mineTestBlock(source.GetLastBlock())
+	forged.Header.Difficulty = 64
+	forged.Hash = forged.CalculateHash()
+	source.blocks = append(source.blocks, forged)
+
+	a := startNodeWithChain(t, source, network.Config{})
+	b := startNode(t, genesis, a.server.Addr())
+	require.Equal(t, 1, b.server.PeerCount())
+
+	var err error
+	require.Eventually(t, func() bool {
+		err = b.server.Sync(context.Background(), b.server.Peers()[0])
+		return !errors.Is(err, network.ErrSyncInProgress)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, err, network.ErrInvalidHeaderChain)
+	assert.Equal(t, uint64(0), b.chain.Height(), "No block should be imported from an invalid header chain")
+}
+
+func TestServer_SyncVerifiesHeadersWithEngine(t *testing.T) {
+	genesis := testGenesis("GENESIS")
+
+	source := newMemChain(genesis)
+	source.extendChain(t, 3)
+
+	// Real proof-of-work, but at a difficulty the engine does not allow
+	cheap := mineTestBlock(source.GetLastBlock())
+	cheap.Header.Difficulty = 1
+	for nonce := uint64(0); ; nonce++ {
+		cheap.Header.Nonce = nonce
+		if cheap.Hash = cheap.CalculateHash(); cheap.Validate() == nil {
+			break
+		}
+	}
+	source.blocks = append(source.blocks, cheap)
+
+	a := startNodeWithChain(t, source, network.Config{})
+	b := startNode(t, genesis, a.server.Addr())
+	require.Equal(t, 1, b.server.PeerCount())
+
+	var err error
+	require.Eventually(t, func() bool {
+		err = b.server.Sync(context.Background(), b.server.Peers()[0])
+		return !errors.Is(err, network.ErrSyncInProgress)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ErrorIs(t, err, network.ErrInvalidHeaderChain)
+	assert.ErrorIs(t, err, consensus.ErrInvalidDifficulty)
+	assert.Equal(t, uint64(0), b.chain.Height())
+}
@@ -0,0 +1,84 @@
+package state
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/karimseh/gochain/pkg/types"
+)
+
+// Journal records the values a block overwrote, so the block can be undone
+// without replaying the chain.
+type Journal struct {
+	Accounts []JournalEntry `json:"accounts"`
+}
+
+type JournalEntry struct {
+	Address  string         `json:"address"`
+	Previous *types.Account `json:"previous"` // nil if the account did not exist
+}
+
+// Journal returns the previous values of every account a scratch copy has
+// written, as currently seen by its parent.
+func (s *State) Journal() (*Journal, error) {
+	s.mu.RLock()
+	addresses := make([]string, 0, len(s.dirty))
+	for address := range s.dirty {
+		addresses = append(addresses, address)
+	}
+	s.mu.RUnlock()
+	sort.Strings(addresses)
+
+	journal := &Journal{Accounts: make([]JournalEntry, 0, len(addresses))}
+	for _, address := range addresses {
+		entry := JournalEntry{Address: address}
+		if s.parent != nil {
+			prev, exists, err := s.parent.lookup(address)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				copied := *prev
+				entry.Previous = &copied
+			}
+		}
+		journal.Accounts = append(journal.Accounts, entry)
+	}
+	return journal, nil
+}
+
+// RevertJournal restores the accounts recorded in journal.
+func (s *State) RevertJournal(journal *Journal) error {
+	if s.parent == nil {
+		scratch := s.Copy()
+		if err := scratch.RevertJournal(journal); err != nil {
+			return err
+		}
+		return s.Commit(scratch)
+	}
+
+	for _, entry := range journal.Accounts {
+		if entry.Previous == nil {
+			if err := s.DeleteAccount(entry.Address); err != nil {
+				return err
+			}
+			continue
+		}
+		copied := *entry.Previous
+		if err := s.SaveAccount(&copied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Journal) Serialize() []byte {
+	data, _ := json.Marshal(j)
+	return data
+}
+
+func DeserializeJournal(data []byte) (*Journal, error) {
+	var journal Journal
+	err := json.Unmarshal(data, &journal)
+	return &journal, err
+}
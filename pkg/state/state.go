@@ -2,30 +2,115 @@ package state
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/bits"
 	"sort"
 	"sync"
 
-	"github.com/dgraph-io/badger/v4"
 	"github.com/karimseh/gochain/pkg/crypto"
+	"github.com/karimseh/gochain/pkg/storage"
 	"github.com/karimseh/gochain/pkg/types"
 )
 
 type State struct {
-	db    *badger.DB
-	cache map[string]*types.Account
-	mu    sync.RWMutex
+	db     storage.KV
+	parent *State
+	cache  map[string]*types.Account
+	dirty  map[string]bool // Accounts written by a scratch copy, nil in cache when deleted
+	mu     sync.RWMutex
+
+	chainID uint64
 }
 
-func NewState(db *badger.DB) *State {
+func NewState(db storage.KV) *State {
 	return &State{
 		db:    db,
 		cache: make(map[string]*types.Account),
 	}
 }
-func (s *State) GetBalance(address string) (uint64, error) {
+
+// Copy returns a scratch state on top of s. Reads fall through to s while
+// writes stay in memory, so blocks can be executed without touching s.
+func (s *State) Copy() *State {
+	return &State{
+		db:      s.db,
+		parent:  s,
+		chainID: s.chainID,
+		cache:   make(map[string]*types.Account),
+		dirty:   make(map[string]bool),
+	}
+}
+
+// WriteTo stages the accounts changed by scratch copy s into w, usually a
+// batch, so they can be committed together with other chain data.
+func (s *State) WriteTo(w storage.Writer) error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+
+	for address := range s.dirty {
+		acc := s.cache[address]
+		if acc == nil {
+			if err := w.Delete(storage.AccountKey(address)); err != nil {
+				return err
+			}
+			continue
+		}
+		data, err := json.Marshal(acc)
+		if err != nil {
+			return err
+		}
+		if err := w.Put(storage.AccountKey(address), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge takes over the writes of scratch, a copy of s. On a database backed
+// state it must only be called once they have been committed with WriteTo.
+func (s *State) Merge(scratch *State) {
+	scratch.mu.RLock()
+	defer scratch.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address := range scratch.dirty {
+		acc := scratch.cache[address]
+		if s.parent != nil {
+			s.dirty[address] = true
+		}
+		if acc == nil {
+			if s.parent != nil {
+				s.cache[address] = nil
+			} else {
+				delete(s.cache, address)
+			}
+			continue
+		}
+		copied := *acc
+		s.cache[address] = &copied
+	}
+}
+
+// Commit writes the changes of scratch to s in a single transaction.
+func (s *State) Commit(scratch *State) error {
+	if s.parent != nil {
+		s.Merge(scratch)
+		return nil
+	}
+	batch := s.db.NewBatch()
+	if err := scratch.WriteTo(batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	s.Merge(scratch)
+	return nil
+}
+
+func (s *State) GetBalance(address string) (uint64, error) {
 	acc, err := s.GetAccount(address)
 	if err != nil {
 		return 0, err
@@ -34,31 +119,49 @@ func (s *State) GetBalance(address string) (uint64, error) {
 }
 
 func (s *State) GetAccount(address string) (*types.Account, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	acc, exists, err := s.lookup(address)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return &types.Account{Address: address, Balance: 0, Nonce: 0}, nil
+	}
+	return acc, nil
+}
+
+// lookup returns the stored account and whether it exists at all, which
+// GetAccount hides behind an empty account.
+func (s *State) lookup(address string) (*types.Account, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	if acc, exists := s.cache[address]; exists {
-		return acc, nil
+		return acc, acc != nil, nil
 	}
 
-	var acc *types.Account
-	err := s.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("account-" + address))
-		if err != nil {
-			return err
+	if s.parent != nil {
+		acc, exists, err := s.parent.lookup(address)
+		if err != nil || !exists {
+			return nil, false, err
 		}
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &acc)
-		})
-	})
+		copied := *acc // Never hand out the parent's pointer
+		s.cache[address] = &copied
+		return &copied, true, nil
+	}
 
-	if err == badger.ErrKeyNotFound {
-		return &types.Account{Address: address, Balance: 0, Nonce: 0}, nil
+	data, err := s.db.Get(storage.AccountKey(address))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, false, nil
 	}
-	if err == nil {
-		s.cache[address] = acc
+	if err != nil {
+		return nil, false, err
 	}
-	return acc, err
+	var acc *types.Account
+	if err := json.Unmarshal(data, &acc); err != nil {
+		return nil, false, err
+	}
+	s.cache[address] = acc
+	return acc, true, nil
 }
 
 func (s *State) SaveAccount(acc *types.Account) error {
@@ -66,16 +169,40 @@ func (s *State) SaveAccount(acc *types.Account) error {
 	defer s.mu.Unlock()
 
 	s.cache[acc.Address] = acc
+	if s.parent != nil {
+		s.dirty[acc.Address] = true
+		return nil
+	}
 	data, err := json.Marshal(acc)
 	if err != nil {
 		return err
 	}
-	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte("account-"+acc.Address), data)
-	})
+	return s.db.Put(storage.AccountKey(acc.Address), data)
+}
+
+func (s *State) DeleteAccount(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.parent != nil {
+		s.cache[address] = nil
+		s.dirty[address] = true
+		return nil
+	}
+	delete(s.cache, address)
+	return s.db.Delete(storage.AccountKey(address))
+}
+
+// SetChainID makes s reject transactions signed for another chain. Copies
+// made afterwards inherit it.
+func (s *State) SetChainID(chainID uint64) {
+	s.chainID = chainID
 }
 
 func (s *State) ValidateTx(tx *types.Transaction) error {
+	if tx.ChainID != s.chainID {
+		return fmt.Errorf("wrong chain ID: %d, expected: %d", tx.ChainID, s.chainID)
+	}
 	if !tx.Verify() {
 		return fmt.Errorf("transaction signature verification failed")
 	}
@@ -92,8 +219,12 @@ func (s *State) ValidateTx(tx *types.Transaction) error {
 		return fmt.Errorf("invalid nonce: %d, expected: %d", tx.Nonce, sender.Nonce+1)
 	}
 
-	if sender.Balance < tx.Ammount {
-		return fmt.Errorf("insufficient balance: %d, required: %d", sender.Balance, tx.Ammount)
+	cost, ok := tx.Cost()
+	if !ok {
+		return fmt.Errorf("transaction cost overflows")
+	}
+	if sender.Balance < cost {
+		return fmt.Errorf("insufficient balance: %d, required: %d", sender.Balance, cost)
 	}
 	return nil
 }
@@ -102,41 +233,69 @@ func (s *State) ApplyTx(tx *types.Transaction) error {
 		return err
 	}
 	sender, _ := s.GetAccount(tx.From)
-	sender.Balance -= tx.Ammount
+	cost, _ := tx.Cost()
+	sender.Balance -= cost
 	sender.Nonce = tx.Nonce
 	if err := s.SaveAccount(sender); err != nil {
 		return err
 	}
 
-	reciever, _ := s.GetAccount(tx.To)
-	reciever.Balance += tx.Ammount
-	return s.SaveAccount(reciever)
-
+	return s.credit(tx.To, tx.Ammount)
 }
 
 func (s *State) ApplyBlock(block *types.Block) error {
+	if s.parent == nil {
+		// Execute on a scratch copy so a failing transaction leaves no trace
+		scratch := s.Copy()
+		if err := scratch.ApplyBlock(block); err != nil {
+			return err
+		}
+		return s.Commit(scratch)
+	}
+
 	coinbase := block.Transactions[0]
 	if err := s.ApplyCoinbase(coinbase); err != nil {
 		return err
 	}
 
+	var fees uint64
 	for _, tx := range block.Transactions[1:] {
 		if err := s.ApplyTx(tx); err != nil {
 			return err
 		}
+		var carry uint64
+		if fees, carry = bits.Add64(fees, tx.Fee, 0); carry != 0 {
+			return fmt.Errorf("block fees overflow")
+		}
 	}
-	return nil
+
+	// Fees go to whoever the coinbase pays
+	if fees == 0 {
+		return nil
+	}
+	return s.credit(coinbase.To, fees)
 }
 
 func (s *State) ApplyCoinbase(tx *types.Transaction) error {
-	acc, _ := s.GetAccount(tx.To)
-	acc.Balance += tx.Ammount
+	return s.credit(tx.To, tx.Ammount)
+}
+
+// credit adds amount to the balance of address, failing rather than wrapping
+// around.
+func (s *State) credit(address string, amount uint64) error {
+	acc, err := s.GetAccount(address)
+	if err != nil {
+		return err
+	}
+	balance, carry := bits.Add64(acc.Balance, amount, 0)
+	if carry != 0 {
+		return fmt.Errorf("balance of %s overflows", address)
+	}
+	acc.Balance = balance
 	return s.SaveAccount(acc)
 }
 
 func (s *State) GetNextNonce(address string) (uint64, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
 	acc, err := s.GetAccount(address)
 	if err != nil {
 		return 0, err
@@ -145,9 +304,6 @@ func (s *State) GetNextNonce(address string) (uint64, error) {
 }
 
 func (s *State) CalculateStateRoot() ([]byte, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	// Get all accounts from DB
 	accounts, err := s.getAllAccounts()
 	if err != nil {
@@ -172,34 +328,50 @@ func (s *State) CalculateStateRoot() ([]byte, error) {
 }
 
 func (s *State) getAllAccounts() ([]*types.Account, error) {
-	var accounts []*types.Account
+	if s.parent != nil {
+		return s.mergeDirty()
+	}
 
-	err := s.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = []byte("account-")
-
-		it := txn.NewIterator(opts)
-		defer it.Close()
-
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			err := item.Value(func(v []byte) error {
-				var acc types.Account
-				if err := json.Unmarshal(v, &acc); err != nil {
-					return err
-				}
-				accounts = append(accounts, &acc)
-				return nil
-			})
-			if err != nil {
-				return err
-			}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var accounts []*types.Account
+	err := s.db.Iterate([]byte(storage.AccountPrefix), func(key, value []byte) error {
+		var acc types.Account
+		if err := json.Unmarshal(value, &acc); err != nil {
+			return err
 		}
+		accounts = append(accounts, &acc)
 		return nil
 	})
-
 	return accounts, err
 }
+
+// mergeDirty lays the accounts written by a scratch copy over its parent's.
+func (s *State) mergeDirty() ([]*types.Account, error) {
+	base, err := s.parent.getAllAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]*types.Account, 0, len(base)+len(s.dirty))
+	for _, acc := range base {
+		if !s.dirty[acc.Address] {
+			accounts = append(accounts, acc)
+		}
+	}
+	for address := range s.dirty {
+		if acc := s.cache[address]; acc != nil {
+			copied := *acc
+			accounts = append(accounts, &copied)
+		}
+	}
+	return accounts, nil
+}
+
 func serializeAccount(acc *types.Account) ([]byte, error) {
 	return json.Marshal(struct {
 		Address string
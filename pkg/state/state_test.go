@@ -1,13 +1,12 @@
 package state_test
 
 import (
-	"os"
-	"path/filepath"
+	"math"
 	"testing"
 
-	"github.com/dgraph-io/badger/v4"
 	"github.com/karimseh/gochain/pkg/crypto"
 	"github.com/karimseh/gochain/pkg/state"
+	"github.com/karimseh/gochain/pkg/storage"
 	"github.com/karimseh/gochain/pkg/types"
 	"github.com/karimseh/gochain/pkg/wallet"
 	"github.com/stretchr/testify/assert"
@@ -15,18 +14,9 @@ import (
 )
 
 func setupState(t *testing.T) (*state.State, func()) {
-	dir := filepath.Join(os.TempDir(), "badger-test", t.Name())
-	_ = os.RemoveAll(dir)
-
-	opts := badger.DefaultOptions(dir)
-	opts.Logger = nil // Disable logger for tests
-
-	db, err := badger.Open(opts)
-	require.NoError(t, err)
-
+	db := storage.NewMemory()
 	return state.NewState(db), func() {
 		db.Close()
-		os.RemoveAll(dir)
 	}
 }
 
@@ -95,7 +85,7 @@ func TestValidateTx(t *testing.T) {
 		Nonce:   0,
 	}))
 
-	tx := types.NewTransaction(w.Address, "recipient", 50, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	tx := types.NewTransaction(0, w.Address, "recipient", 50, 1, crypto.PublicKeyToBytes(w.PublicKey))
 	require.NoError(t, tx.Sign(w))
 
 	t.Run("Valid Transaction", func(t *testing.T) {
@@ -115,6 +105,20 @@ func TestValidateTx(t *testing.T) {
 		_ = badTx.Sign(w)
 		assert.ErrorContains(t, s.ValidateTx(&badTx), "insufficient balance")
 	})
+
+	t.Run("Fee Exceeds Balance", func(t *testing.T) {
+		badTx := *tx
+		badTx.Fee = 51
+		_ = badTx.Sign(w)
+		assert.ErrorContains(t, s.ValidateTx(&badTx), "insufficient balance")
+	})
+
+	t.Run("Cost Overflow", func(t *testing.T) {
+		badTx := *tx
+		badTx.Fee = ^uint64(0)
+		_ = badTx.Sign(w)
+		assert.ErrorContains(t, s.ValidateTx(&badTx), "overflows")
+	})
 	t.Run("Invalid Hash", func(t *testing.T) {
 		badTx := *tx
 		badTx.Ammount = 1
@@ -126,6 +130,21 @@ func TestValidateTx(t *testing.T) {
 		badTx.Signature[0]++
 		assert.ErrorContains(t, s.ValidateTx(&badTx), "signature verification")
 	})
+
+	t.Run("Wrong Chain ID", func(t *testing.T) {
+		other := s.Copy()
+		other.SetChainID(7)
+		assert.ErrorContains(t, other.ValidateTx(tx), "wrong chain ID")
+
+		replayed := *tx
+		replayed.ChainID = 7
+		assert.ErrorContains(t, other.ValidateTx(&replayed), "signature verification", "Chain ID is signed")
+
+		signed := *tx
+		signed.ChainID = 7
+		require.NoError(t, signed.Sign(w))
+		assert.NoError(t, other.ValidateTx(&signed))
+	})
 }
 
 func TestApplyTx(t *testing.T) {
@@ -139,7 +158,7 @@ func TestApplyTx(t *testing.T) {
 		Nonce:   0,
 	}))
 
-	tx := types.NewTransaction(w.Address, "recipient", 50, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	tx := types.NewTransaction(0, w.Address, "recipient", 50, 1, crypto.PublicKeyToBytes(w.PublicKey))
 	require.NoError(t, tx.Sign(w))
 
 	t.Run("Valid Transaction", func(t *testing.T) {
@@ -176,7 +195,8 @@ func TestApplyBlock(t *testing.T) {
 		Nonce:   0,
 	}))
 
-	tx := types.NewTransaction(w.Address, "recipient", 200, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	tx := types.NewTransaction(0, w.Address, "recipient", 200, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	tx.Fee = 5
 	require.NoError(t, tx.Sign(w))
 
 	block := types.NewBlock(1, []*types.Transaction{coinbase, tx}, make([]byte, 32), minerWallet.Address)
@@ -186,18 +206,158 @@ func TestApplyBlock(t *testing.T) {
 
 	t.Run("Coinbase Applied", func(t *testing.T) {
 		minerAcc, _ := s.GetAccount(minerWallet.Address)
-		assert.Equal(t, uint64(types.CoinbaseAmount), minerAcc.Balance)
+		assert.Equal(t, uint64(types.CoinbaseAmount+5), minerAcc.Balance, "Reward plus fees")
 	})
 
 	t.Run("Transaction Applied", func(t *testing.T) {
 		sender, _ := s.GetAccount(w.Address)
-		assert.Equal(t, uint64(800), sender.Balance)
+		assert.Equal(t, uint64(795), sender.Balance, "Amount plus fee debited")
 
 		receiver, _ := s.GetAccount("recipient")
 		assert.Equal(t, uint64(200), receiver.Balance)
 	})
 }
 
+func TestApplyBlock_Atomic(t *testing.T) {
+	s, cleanup := setupState(t)
+	defer cleanup()
+
+	w := wallet.NewWallet()
+	require.NoError(t, s.SaveAccount(&types.Account{Address: w.Address, Balance: 100}))
+	rootBefore, err := s.CalculateStateRoot()
+	require.NoError(t, err)
+
+	tx1 := types.NewTransaction(0, w.Address, "recipient", 60, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	require.NoError(t, tx1.Sign(w))
+	tx2 := types.NewTransaction(0, w.Address, "recipient", 60, 2, crypto.PublicKeyToBytes(w.PublicKey))
+	require.NoError(t, tx2.Sign(w))
+
+	block := types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx("miner"), tx1, tx2}, make([]byte, 32), "miner")
+	assert.ErrorContains(t, s.ApplyBlock(block), "insufficient balance")
+
+	miner, _ := s.GetAccount("miner")
+	assert.Zero(t, miner.Balance, "Coinbase of a failed block must not be credited")
+	sender, _ := s.GetAccount(w.Address)
+	assert.Equal(t, uint64(100), sender.Balance)
+	assert.Zero(t, sender.Nonce)
+
+	rootAfter, err := s.CalculateStateRoot()
+	require.NoError(t, err)
+	assert.Equal(t, rootBefore, rootAfter)
+}
+
+func TestApplyBlock_Overflow(t *testing.T) {
+	w := wallet.NewWallet()
+	newBlock := func(t *testing.T, fee uint64) *types.Block {
+		tx := types.NewTransaction(0, w.Address, "recipient", 10, 1, crypto.PublicKeyToBytes(w.PublicKey))
+		tx.Fee = fee
+		require.NoError(t, tx.Sign(w))
+		return types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx("miner"), tx}, make([]byte, 32), "miner")
+	}
+
+	tests := []struct {
+		name     string
+		accounts []*types.Account
+	}{
+		{"Coinbase Credit", []*types.Account{{Address: "miner", Balance: math.MaxUint64}}},
+		{"Fee Credit", []*types.Account{{Address: "miner", Balance: math.MaxUint64 - types.CoinbaseAmount}}},
+		{"Recipient Credit", []*types.Account{{Address: "recipient", Balance: math.MaxUint64 - 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, cleanup := setupState(t)
+			defer cleanup()
+			require.NoError(t, s.SaveAccount(&types.Account{Address: w.Address, Balance: 100}))
+			for _, acc := range tt.accounts {
+				require.NoError(t, s.SaveAccount(acc))
+			}
+
+			assert.ErrorContains(t, s.ApplyBlock(newBlock(t, 5)), "overflows")
+			sender, _ := s.GetAccount(w.Address)
+			assert.Equal(t, uint64(100), sender.Balance)
+		})
+	}
+}
+
+func TestRevertJournal(t *testing.T) {
+	s, cleanup := setupState(t)
+	defer cleanup()
+
+	w := wallet.NewWallet()
+	require.NoError(t, s.SaveAccount(&types.Account{
+		Address: w.Address,
+		Balance: 1000,
+		Nonce:   0,
+	}))
+	rootBefore, err := s.CalculateStateRoot()
+	require.NoError(t, err)
+
+	tx := types.NewTransaction(0, w.Address, "recipient", 200, 1, crypto.PublicKeyToBytes(w.PublicKey))
+	require.NoError(t, tx.Sign(w))
+	block := types.NewBlock(1, []*types.Transaction{types.NewCoinbaseTx("miner"), tx}, make([]byte, 32), "miner")
+
+	scratch := s.Copy()
+	require.NoError(t, scratch.ApplyBlock(block))
+	journal, err := scratch.Journal()
+	require.NoError(t, err)
+	require.NoError(t, s.Commit(scratch))
+
+	assert.Len(t, journal.Accounts, 3, "Sender, recipient and miner were touched")
+
+	require.NoError(t, s.RevertJournal(journal))
+
+	sender, _ := s.GetAccount(w.Address)
+	assert.Equal(t, uint64(1000), sender.Balance)
+	assert.Equal(t, uint64(0), sender.Nonce)
+
+	rootAfter, err := s.CalculateStateRoot()
+	require.NoError(t, err)
+	assert.Equal(t, rootBefore, rootAfter, "Accounts created by the block should be removed")
+}
+
+func TestCopy(t *testing.T) {
+	s, cleanup := setupState(t)
+	defer cleanup()
+
+	require.NoError(t, s.SaveAccount(&types.Account{Address: "a", Balance: 100, Nonce: 1}))
+	require.NoError(t, s.SaveAccount(&types.Account{Address: "b", Balance: 200, Nonce: 1}))
+	rootBefore, err := s.CalculateStateRoot()
+	require.NoError(t, err)
+
+	scratch := s.Copy()
+	acc, err := scratch.GetAccount("a")
+	require.NoError(t, err)
+	acc.Balance = 50
+	require.NoError(t, scratch.SaveAccount(acc))
+	require.NoError(t, scratch.SaveAccount(&types.Account{Address: "c", Balance: 10}))
+	require.NoError(t, scratch.DeleteAccount("b"))
+
+	t.Run("Parent Untouched", func(t *testing.T) {
+		a, _ := s.GetAccount("a")
+		assert.Equal(t, uint64(100), a.Balance)
+		c, _ := s.GetAccount("c")
+		assert.Zero(t, c.Balance)
+
+		root, err := s.CalculateStateRoot()
+		require.NoError(t, err)
+		assert.Equal(t, rootBefore, root)
+	})
+
+	t.Run("Scratch Root", func(t *testing.T) {
+		expected := s.Copy()
+		_ = expected.SaveAccount(&types.Account{Address: "a", Balance: 50, Nonce: 1})
+		_ = expected.SaveAccount(&types.Account{Address: "c", Balance: 10})
+		_ = expected.DeleteAccount("b")
+
+		root, err := scratch.CalculateStateRoot()
+		require.NoError(t, err)
+		expectedRoot, err := expected.CalculateStateRoot()
+		require.NoError(t, err)
+		assert.Equal(t, expectedRoot, root)
+		assert.NotEqual(t, rootBefore, root)
+	})
+}
+
 func TestCalculateStateRoot(t *testing.T) {
 	s, cleanup := setupState(t)
 	defer cleanup()
@@ -235,7 +395,7 @@ func TestEdgeCases(t *testing.T) {
 		w := wallet.NewWallet()
 		_ = s.SaveAccount(&types.Account{Address: w.Address, Balance: 100})
 
-		tx := types.NewTransaction(w.Address, "empty", 0, 1, crypto.PublicKeyToBytes(w.PublicKey))
+		tx := types.NewTransaction(0, w.Address, "empty", 0, 1, crypto.PublicKeyToBytes(w.PublicKey))
 		_ = tx.Sign(w)
 
 		err := s.ApplyTx(tx)
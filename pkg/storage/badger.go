@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Badger is a KV backed by a badger database.
+type Badger struct {
+	db *badger.DB
+}
+
+// OpenBadger opens or creates the badger database in dir.
+func OpenBadger(dir string) (*Badger, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Badger{db: db}, nil
+}
+
+func (b *Badger) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = get(txn, key)
+		return err
+	})
+	return value, err
+}
+
+func (b *Badger) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return b.db.View(func(txn *badger.Txn) error {
+		return iterate(txn, prefix, fn)
+	})
+}
+
+func (b *Badger) Put(key, value []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (b *Badger) Delete(key []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (b *Badger) NewBatch() Batch {
+	return &badgerBatch{db: b.db}
+}
+
+func (b *Badger) Snapshot() Snapshot {
+	return &badgerSnapshot{txn: b.db.NewTransaction(false)}
+}
+
+func (b *Badger) Close() error {
+	return b.db.Close()
+}
+
+func get(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func iterate(txn *badger.Txn, prefix []byte, fn func(key, value []byte) error) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type badgerOp struct {
+	key, value []byte
+	delete     bool
+}
+
+type badgerBatch struct {
+	db  *badger.DB
+	ops []badgerOp
+}
+
+func (b *badgerBatch) Put(key, value []byte) error {
+	b.ops = append(b.ops, badgerOp{key: bytes.Clone(key), value: bytes.Clone(value)})
+	return nil
+}
+
+func (b *badgerBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, badgerOp{key: bytes.Clone(key), delete: true})
+	return nil
+}
+
+func (b *badgerBatch) Write() error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		for _, op := range b.ops {
+			var err error
+			if op.delete {
+				err = txn.Delete(op.key)
+			} else {
+				err = txn.Set(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+type badgerSnapshot struct {
+	txn *badger.Txn
+}
+
+func (s *badgerSnapshot) Get(key []byte) ([]byte, error) {
+	return get(s.txn, key)
+}
+
+func (s *badgerSnapshot) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return iterate(s.txn, prefix, fn)
+}
+
+func (s *badgerSnapshot) Release() {
+	s.txn.Discard()
+}
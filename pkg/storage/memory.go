@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory is a KV kept in a map, for tests and nodes that need no
+// persistence. Snapshots share the map until the next write copies it.
+type Memory struct {
+	mu     sync.RWMutex
+	data   map[string][]byte
+	shared bool // data is referenced by a snapshot
+}
+
+func NewMemory() *Memory {
+	return &Memory{data: make(map[string][]byte)}
+}
+
+func (m *Memory) Get(key []byte) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return memoryGet(m.data, key)
+}
+
+func (m *Memory) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	m.mu.RLock()
+	entries := memoryCollect(m.data, prefix)
+	m.mu.RUnlock()
+	// fn runs unlocked, it may write to m
+	return visit(entries, fn)
+}
+
+func (m *Memory) Put(key, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.own()
+	m.data[string(key)] = bytes.Clone(value)
+	return nil
+}
+
+func (m *Memory) Delete(key []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.own()
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *Memory) NewBatch() Batch {
+	return &memoryBatch{m: m}
+}
+
+func (m *Memory) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shared = true
+	return memorySnapshot{data: m.data}
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// own copies data before a write if a snapshot still refers to it. It must be
+// called with m.mu held.
+func (m *Memory) own() {
+	if !m.shared {
+		return
+	}
+	data := make(map[string][]byte, len(m.data))
+	for k, v := range m.data {
+		data[k] = v
+	}
+	m.data = data
+	m.shared = false
+}
+
+func memoryGet(data map[string][]byte, key []byte) ([]byte, error) {
+	value, exists := data[string(key)]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(value), nil
+}
+
+type memoryEntry struct {
+	key, value []byte
+}
+
+// memoryCollect returns copies of the entries under prefix, sorted by key.
+func memoryCollect(data map[string][]byte, prefix []byte) []memoryEntry {
+	var keys []string
+	for k := range data {
+		if strings.HasPrefix(k, string(prefix)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	entries := make([]memoryEntry, len(keys))
+	for i, k := range keys {
+		entries[i] = memoryEntry{key: []byte(k), value: bytes.Clone(data[k])}
+	}
+	return entries
+}
+
+func visit(entries []memoryEntry, fn func(key, value []byte) error) error {
+	for _, e := range entries {
+		if err := fn(e.key, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type memoryOp struct {
+	key, value []byte
+	delete     bool
+}
+
+type memoryBatch struct {
+	m   *Memory
+	ops []memoryOp
+}
+
+func (b *memoryBatch) Put(key, value []byte) error {
+	b.ops = append(b.ops, memoryOp{key: bytes.Clone(key), value: bytes.Clone(value)})
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, memoryOp{key: bytes.Clone(key), delete: true})
+	return nil
+}
+
+func (b *memoryBatch) Write() error {
+	b.m.mu.Lock()
+	defer b.m.mu.Unlock()
+	b.m.own()
+	for _, op := range b.ops {
+		if op.delete {
+			delete(b.m.data, string(op.key))
+		} else {
+			b.m.data[string(op.key)] = op.value
+		}
+	}
+	return nil
+}
+
+type memorySnapshot struct {
+	data map[string][]byte
+}
+
+func (s memorySnapshot) Get(key []byte) ([]byte, error) {
+	return memoryGet(s.data, key)
+}
+
+func (s memorySnapshot) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return visit(memoryCollect(s.data, prefix), fn)
+}
+
+func (s memorySnapshot) Release() {}
@@ -0,0 +1,52 @@
+package storage
+
+import "encoding/binary"
+
+// Key schema. Blocks are stored under their raw hash, every other key starts
+// with a name telling what it holds:
+//
+//	lastHash            hash of the canonical tip
+//	<hash>              block, types.Block.Serialize
+//	td-<hash>           total work of the chain ending at the block, big-endian
+//	undo-<hash>         state.Journal reverting the block, for connected blocks
+//	height-<height>     hash of the canonical block at height, height big-endian
+//	tx-<hash>           block hash and position of a canonical transaction, JSON encoded
+//	receipt-<hash>      types.Receipt of a canonical transaction, JSON encoded
+//	account-<address>   account, JSON encoded
+//
+// Block hashes are 32 bytes, so they cannot collide with the named keys.
+
+var LastHashKey = []byte("lastHash")
+
+const (
+	TotalWorkPrefix = "td-"
+	AccountPrefix   = "account-"
+)
+
+func BlockKey(hash []byte) []byte {
+	return hash
+}
+
+func TotalWorkKey(hash []byte) []byte {
+	return append([]byte(TotalWorkPrefix), hash...)
+}
+
+func UndoKey(hash []byte) []byte {
+	return append([]byte("undo-"), hash...)
+}
+
+func HeightKey(height uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte("height-"), height)
+}
+
+func TxKey(hash []byte) []byte {
+	return append([]byte("tx-"), hash...)
+}
+
+func ReceiptKey(hash []byte) []byte {
+	return append([]byte("receipt-"), hash...)
+}
+
+func AccountKey(address string) []byte {
+	return []byte(AccountPrefix + address)
+}
@@ -0,0 +1,47 @@
+// Package storage is the key-value layer the chain and its state are kept
+// in. Consensus code only sees the KV interface, so backends can be swapped
+// without touching it; see schema.go for the keys in use.
+package storage
+
+import "errors"
+
+var ErrNotFound = errors.New("key not found")
+
+// Reader reads from a store or a snapshot of it. Returned values belong to
+// the caller.
+type Reader interface {
+	// Get returns the value stored under key, or ErrNotFound.
+	Get(key []byte) ([]byte, error)
+
+	// Iterate calls fn for every key starting with prefix, in key order,
+	// stopping at the first error fn returns.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+}
+
+// Writer changes keys of a store or a batch.
+type Writer interface {
+	Put(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// Batch collects writes that are applied together by Write, or not at all.
+// Writes are not visible to anyone before Write.
+type Batch interface {
+	Writer
+	Write() error
+}
+
+// Snapshot is a consistent view of a store at the time it was taken. It must
+// be released once done with.
+type Snapshot interface {
+	Reader
+	Release()
+}
+
+type KV interface {
+	Reader
+	Writer
+	NewBatch() Batch
+	Snapshot() Snapshot
+	Close() error
+}
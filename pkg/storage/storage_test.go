@@ -0,0 +1,101 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/karimseh/gochain/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func backends(t *testing.T) map[string]storage.KV {
+	disk, err := storage.OpenBadger(t.TempDir())
+	require.NoError(t, err)
+	return map[string]storage.KV{
+		"Badger": disk,
+		"Memory": storage.NewMemory(),
+	}
+}
+
+func TestKV(t *testing.T) {
+	for name, kv := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			defer kv.Close()
+			testKV(t, kv)
+		})
+	}
+}
+
+func testKV(t *testing.T, kv storage.KV) {
+	t.Run("Get Put Delete", func(t *testing.T) {
+		_, err := kv.Get([]byte("missing"))
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		require.NoError(t, kv.Put([]byte("key"), []byte("value")))
+		value, err := kv.Get([]byte("key"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), value)
+
+		value[0] = 'X'
+		again, err := kv.Get([]byte("key"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), again, "Values are copies")
+
+		require.NoError(t, kv.Delete([]byte("key")))
+		_, err = kv.Get([]byte("key"))
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		require.NoError(t, kv.Put([]byte("gone"), []byte("1")))
+
+		batch := kv.NewBatch()
+		require.NoError(t, batch.Put([]byte("a"), []byte("1")))
+		require.NoError(t, batch.Put([]byte("b"), []byte("2")))
+		require.NoError(t, batch.Delete([]byte("gone")))
+
+		_, err := kv.Get([]byte("a"))
+		assert.ErrorIs(t, err, storage.ErrNotFound, "Nothing visible before Write")
+
+		require.NoError(t, batch.Write())
+		value, err := kv.Get([]byte("b"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("2"), value)
+		_, err = kv.Get([]byte("gone"))
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+
+	t.Run("Iterate Prefix", func(t *testing.T) {
+		for _, key := range []string{"acc-2", "acc-1", "other", "acc-3"} {
+			require.NoError(t, kv.Put([]byte(key), []byte(key)))
+		}
+
+		var keys []string
+		err := kv.Iterate([]byte("acc-"), func(key, value []byte) error {
+			assert.Equal(t, key, value)
+			keys = append(keys, string(key))
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"acc-1", "acc-2", "acc-3"}, keys)
+	})
+
+	t.Run("Snapshot Isolation", func(t *testing.T) {
+		require.NoError(t, kv.Put([]byte("snap"), []byte("old")))
+		snapshot := kv.Snapshot()
+		defer snapshot.Release()
+
+		require.NoError(t, kv.Put([]byte("snap"), []byte("new")))
+		require.NoError(t, kv.Put([]byte("snap-added"), []byte("new")))
+
+		value, err := snapshot.Get([]byte("snap"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("old"), value)
+		_, err = snapshot.Get([]byte("snap-added"))
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		value, err = kv.Get([]byte("snap"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("new"), value)
+	})
+}
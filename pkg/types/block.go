@@ -25,6 +25,9 @@ type BlockHeader struct {
 	Nonce      uint64 `json:"nonce"`
 	Difficulty int    `json:"difficulty"`
 	Miner      string `json:"miner"`
+	Extra      []byte `json:"extra,omitempty"`     // Free data, the genesis block holds its config
+	SignerKey  []byte `json:"signerKey,omitempty"` // Set by engines that sign blocks
+	Signature  []byte `json:"signature,omitempty"` // Signs the block hash, not part of it
 }
 
 func NewBlock(index uint64, transactions []*Transaction, parentHash []byte, miner string) *Block {
@@ -104,8 +107,21 @@ func (b *Block) Validate() error {
 func (b *Block) CalculateHash() []byte {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	headerData, _ := crypto.Serialize(b.Header)
-	return crypto.HashData(headerData, b.MerkleRoot, b.StateRoot)
+	return CalculateBlockHash(b.Header, b.MerkleRoot, b.StateRoot)
+}
+
+// CalculateBlockHash hashes a header with the roots it commits to, so
+// candidate headers can be tried without building a Block.
+func CalculateBlockHash(header BlockHeader, merkleRoot, stateRoot []byte) []byte {
+	header.Signature = nil
+	headerData, _ := crypto.Serialize(header)
+	return crypto.HashData(headerData, merkleRoot, stateRoot)
+}
+
+func (b *Block) HeaderCopy() BlockHeader {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.Header
 }
 
 func CalculateMerkleRoot(txs []*Transaction) []byte {
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type ReceiptStatus uint8
+
+// ReceiptSuccess is the status of every receipt: a transaction that fails
+// invalidates its whole block, so blocks only hold successful ones.
+const ReceiptSuccess ReceiptStatus = 1
+
+func (s ReceiptStatus) String() string {
+	if s == ReceiptSuccess {
+		return "success"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(s))
+}
+
+// Receipt is the outcome of a transaction included in a block.
+type Receipt struct {
+	TxHash      []byte        `json:"txHash"`
+	BlockHash   []byte        `json:"blockHash"`
+	BlockHeight uint64        `json:"blockHeight"`
+	Index       int           `json:"index"`  // Position in the block's transactions
+	Status      ReceiptStatus `json:"status"` // Always ReceiptSuccess
+	FeePaid     uint64        `json:"feePaid"`
+	Nonce       uint64        `json:"nonce"` // Sender nonce once the transaction ran
+}
+
+// NewReceipt describes the transaction at index in block.
+func NewReceipt(block *Block, index int) *Receipt {
+	tx := block.Transactions[index]
+	return &Receipt{
+		TxHash:      tx.Hash,
+		BlockHash:   block.Hash,
+		BlockHeight: block.Header.Index,
+		Index:       index,
+		Status:      ReceiptSuccess,
+		FeePaid:     tx.Fee,
+		Nonce:       tx.Nonce,
+	}
+}
+
+func (r *Receipt) Serialize() []byte {
+	data, _ := json.Marshal(r)
+	return data
+}
+
+func DeserializeReceipt(data []byte) (*Receipt, error) {
+	var receipt Receipt
+	err := json.Unmarshal(data, &receipt)
+	return &receipt, err
+}
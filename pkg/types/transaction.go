@@ -2,28 +2,34 @@ package types
 
 import (
 	"bytes"
+	"encoding/json"
 
 	"github.com/karimseh/gochain/pkg/crypto"
 	"github.com/karimseh/gochain/pkg/wallet"
 )
 
 const (
-	CoinbaseAmount = 50 // Block reward
+	CoinbaseAmount = 50 // Default block reward
 	CoinbaseNonce  = 0
 )
 
 type Transaction struct {
 	From      string `json:"from"`
 	To        string `json:"to"`
+	ChainID   uint64 `json:"chainId"` // Chain the transaction is valid on
 	Ammount   uint64 `json:"ammount"`
+	Fee       uint64 `json:"fee"`
 	Nonce     uint64 `json:"nonce"`
 	Signature []byte `json:"signature"`
 	Hash      []byte `json:"hash"`
 	PubKey    []byte `json:"pubkey"`
 }
 
-func NewTransaction(from, to string, ammount, nonce uint64, pubKey []byte) *Transaction {
+// NewTransaction returns an unsigned transfer valid on the chain chainID. The
+// chain ID is part of the signed hash, so it must be known before Sign.
+func NewTransaction(chainID uint64, from, to string, ammount, nonce uint64, pubKey []byte) *Transaction {
 	return &Transaction{
+		ChainID: chainID,
 		From:    from,
 		To:      to,
 		Ammount: ammount,
@@ -42,11 +48,13 @@ func (tx *Transaction) Sign(wallet *wallet.Wallet) error {
 	return nil
 }
 
+// Verify checks the signature of tx. The signed hash covers ChainID, so a
+// transaction cannot be moved to another chain, but checking that it matches
+// the local one is up to the caller. The amount of a coinbase is the chain's
+// block reward and is left to block validation.
 func (tx *Transaction) Verify() bool {
 	if tx.IsCoinbase() {
-		return tx.Ammount == CoinbaseAmount &&
-			tx.To != "" &&
-			len(tx.Signature) == 0
+		return tx.To != "" && len(tx.Signature) == 0
 	}
 	if !bytes.Equal(tx.Hash, tx.CalculateHash()) {
 		return false
@@ -65,13 +73,33 @@ func (tx *Transaction) Verify() bool {
 }
 
 func (tx *Transaction) CalculateHash() []byte {
-	return crypto.HashData([]byte(tx.From), []byte(tx.To), crypto.Uint64ToBytes(tx.Ammount), crypto.Uint64ToBytes(tx.Nonce))
+	return crypto.HashData(crypto.Uint64ToBytes(tx.ChainID), []byte(tx.From), []byte(tx.To), crypto.Uint64ToBytes(tx.Ammount), crypto.Uint64ToBytes(tx.Fee), crypto.Uint64ToBytes(tx.Nonce))
+}
+
+// Cost is what the sender pays for the transaction: amount plus fee. ok is
+// false if the sum overflows.
+func (tx *Transaction) Cost() (cost uint64, ok bool) {
+	cost = tx.Ammount + tx.Fee
+	return cost, cost >= tx.Ammount
+}
+
+// Size is the encoded size of the transaction in bytes, used to price its
+// fee.
+func (tx *Transaction) Size() int {
+	data, _ := json.Marshal(tx)
+	return len(data)
 }
 
 func NewCoinbaseTx(minerAddress string) *Transaction {
+	return NewRewardTx(minerAddress, CoinbaseAmount)
+}
+
+// NewRewardTx is a coinbase paying reward, for chains not using the default
+// block reward.
+func NewRewardTx(minerAddress string, reward uint64) *Transaction {
 	tx := &Transaction{
 		To:      minerAddress,
-		Ammount: CoinbaseAmount,
+		Ammount: reward,
 		Nonce:   CoinbaseNonce,
 	}
 	tx.Hash = crypto.HashData()
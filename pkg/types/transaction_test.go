@@ -15,7 +15,7 @@ func TestTrabsaction_SignAndVerify(t *testing.T) {
 	pubKeyBytes := crypto.PublicKeyToBytes(w.PublicKey)
 
 	t.Run("Valid Tx", func(t *testing.T) {
-		tx := types.NewTransaction(w.Address, "to", 10, 0, pubKeyBytes)
+		tx := types.NewTransaction(0, w.Address, "to", 10, 0, pubKeyBytes)
 		err := tx.Sign(w)
 		assert.NoError(t, err)
 		assert.NotEmpty(t, tx.Signature)
@@ -24,29 +24,36 @@ func TestTrabsaction_SignAndVerify(t *testing.T) {
 	})
 
 	t.Run("Invalid Tx", func(t *testing.T) {
-		tx := types.NewTransaction(w.Address, "to", 10, 0, pubKeyBytes)
+		tx := types.NewTransaction(0, w.Address, "to", 10, 0, pubKeyBytes)
 		tx.Signature = []byte("invalid")
 		assert.False(t, tx.Verify())
 	})
 
 	t.Run("Wrong pk", func(t *testing.T) {
-		tx := types.NewTransaction(w.Address, "to", 10, 0, []byte("wrong"))
+		tx := types.NewTransaction(0, w.Address, "to", 10, 0, []byte("wrong"))
 		_ = tx.Sign(w)
 		assert.False(t, tx.Verify())
 	})
 }
 
 func TestTransaction_CalculateHash(t *testing.T) {
-	tx1 := types.NewTransaction("A", "B", 100, 1, []byte("pubkey"))
+	tx1 := types.NewTransaction(0, "A", "B", 100, 1, []byte("pubkey"))
 	hash1 := tx1.CalculateHash()
 
-	tx2 := types.NewTransaction("A", "B", 100, 1, []byte("pubkey"))
+	tx2 := types.NewTransaction(0, "A", "B", 100, 1, []byte("pubkey"))
 	hash2 := tx2.CalculateHash()
 	assert.Equal(t, hash1, hash2, "Same transactions should have same hash")
 
-	tx3 := types.NewTransaction("A", "B", 100, 2, []byte("pubkey"))
+	tx3 := types.NewTransaction(0, "A", "B", 100, 2, []byte("pubkey"))
 	hash3 := tx3.CalculateHash()
 	assert.NotEqual(t, hash1, hash3, "Different amount should change hash")
+
+	tx4 := types.NewTransaction(0, "A", "B", 100, 1, []byte("pubkey"))
+	tx4.Fee = 1
+	assert.NotEqual(t, hash1, tx4.CalculateHash(), "Different fee should change hash")
+
+	tx5 := types.NewTransaction(2, "A", "B", 100, 1, []byte("pubkey"))
+	assert.NotEqual(t, hash1, tx5.CalculateHash(), "Different chain ID should change hash")
 }
 
 func TestTransaction_VerifyCoinbase(t *testing.T) {
@@ -55,9 +62,8 @@ func TestTransaction_VerifyCoinbase(t *testing.T) {
 		assert.True(t, cbTx.Verify())
 	})
 
-	t.Run("Invalid Coinbase Amount", func(t *testing.T) {
-		cbTx := types.NewCoinbaseTx("miner")
-		cbTx.Ammount = 51
+	t.Run("Coinbase Without Recipient", func(t *testing.T) {
+		cbTx := types.NewRewardTx("", 10)
 		assert.False(t, cbTx.Verify())
 	})
 
@@ -9,7 +9,8 @@ import (
 	"github.com/karimseh/gochain/pkg/crypto"
 )
 
-const walletDir = "wallets"
+// DefaultDir holds the wallets of SaveToFile and LoadWallet.
+const DefaultDir = "wallets"
 
 type Wallet struct {
 	PrivateKey crypto.PrivateKey `json:"private_key"`
@@ -32,17 +33,22 @@ func NewWallet() *Wallet {
 }
 
 func (w *Wallet) SaveToFile() error {
-	if err := os.MkdirAll(walletDir, 0700); err != nil {
+	return w.SaveToDir(DefaultDir)
+}
+
+// SaveToDir writes the wallet to dir, named after its address.
+func (w *Wallet) SaveToDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
 
-	walletFile := filepath.Join(walletDir, w.Address+".json")
+	walletFile := filepath.Join(dir, w.Address+".json")
 	data := struct {
 		PrivateKey string `json:"privateKey"`
 		PublicKey  string `json:"publicKey"`
 	}{
 		PrivateKey: hex.EncodeToString(w.PrivateKey.D.Bytes()),
-		PublicKey:  hex.EncodeToString(append(w.PublicKey.X.Bytes(), w.PublicKey.Y.Bytes()...)),
+		PublicKey:  hex.EncodeToString(crypto.PublicKeyToBytes(w.PublicKey)),
 	}
 
 	file, err := json.MarshalIndent(data, "", " ")
@@ -54,7 +60,12 @@ func (w *Wallet) SaveToFile() error {
 }
 
 func LoadWallet(address string) (*Wallet, error) {
-	walletFile := filepath.Join(walletDir, address+".json")
+	return LoadWalletFrom(DefaultDir, address)
+}
+
+// LoadWalletFrom reads the wallet of address saved in dir.
+func LoadWalletFrom(dir, address string) (*Wallet, error) {
+	walletFile := filepath.Join(dir, address+".json")
 	data, err := os.ReadFile(walletFile)
 	if err != nil {
 		return nil, err